@@ -0,0 +1,139 @@
+package main
+
+import (
+	"image"
+)
+
+// ATLAS_SIZE is the width and height of each atlas texture, which every
+// OpenGL ES 2.0 device we care about supports
+const ATLAS_SIZE = 2048
+
+// AtlasSlot is where an image was put in a TextureAtlas
+type AtlasSlot struct {
+	Texture Texture
+	// Src is the area of the texture holding the image
+	Src   Rect
+	page  int
+	index int
+}
+
+// TextureAtlas packs equally sized images, such as tiles, into a few large
+// textures so that they can be drawn without switching textures. Slots are
+// reused once their image is removed.
+type TextureAtlas struct {
+	renderer Renderer
+	size     int
+	slotSize int
+	pages    []Texture
+	free     []AtlasSlot
+	used     int
+}
+
+func NewTextureAtlas(renderer Renderer, size int, slotSize int) *TextureAtlas {
+	return &TextureAtlas{
+		renderer: renderer,
+		size:     size,
+		slotSize: slotSize,
+	}
+}
+
+func (a *TextureAtlas) slotsPerRow() int {
+	if a.slotSize <= 0 {
+		return 0
+	}
+	return a.size / a.slotSize
+}
+
+// addPage creates another texture and makes all of its slots free
+func (a *TextureAtlas) addPage() error {
+	texture, err := a.renderer.NewTexture(a.size, a.size)
+	if err != nil {
+		return err
+	}
+	page := len(a.pages)
+	a.pages = append(a.pages, texture)
+
+	// Pushed in reverse so that slots fill up from the top left
+	perRow := a.slotsPerRow()
+	for index := perRow*perRow - 1; index >= 0; index-- {
+		a.free = append(a.free, AtlasSlot{
+			Texture: texture,
+			page:    page,
+			index:   index,
+		})
+	}
+
+	return nil
+}
+
+// Add uploads the image into a free slot. Images too large for a slot get a
+// texture of their own.
+func (a *TextureAtlas) Add(img image.Image) (AtlasSlot, error) {
+	size := img.Bounds().Size()
+	if a.slotsPerRow() == 0 || size.X > a.slotSize || size.Y > a.slotSize {
+		texture, err := a.renderer.Upload(img)
+		if err != nil {
+			return AtlasSlot{}, err
+		}
+
+		return AtlasSlot{Texture: texture, Src: FullTexture, page: -1}, nil
+	}
+
+	if len(a.free) == 0 {
+		if err := a.addPage(); err != nil {
+			return AtlasSlot{}, err
+		}
+	}
+	slot := a.free[len(a.free)-1]
+
+	perRow := a.slotsPerRow()
+	x := (slot.index % perRow) * a.slotSize
+	y := (slot.index / perRow) * a.slotSize
+	if err := a.renderer.UploadRegion(slot.Texture, x, y, img); err != nil {
+		return AtlasSlot{}, err
+	}
+	a.free = a.free[:len(a.free)-1]
+	a.used++
+
+	// Inset by half a texel so filtering never samples the neighbouring slot
+	atlasSize := float32(a.size)
+	slot.Src = Rect{
+		X: (float32(x) + 0.5) / atlasSize,
+		Y: (float32(y) + 0.5) / atlasSize,
+		W: (float32(size.X) - 1.0) / atlasSize,
+		H: (float32(size.Y) - 1.0) / atlasSize,
+	}
+
+	return slot, nil
+}
+
+// Remove frees the slot for reuse
+func (a *TextureAtlas) Remove(slot AtlasSlot) {
+	if slot.page < 0 {
+		a.renderer.Delete(slot.Texture)
+		return
+	}
+
+	slot.Src = Rect{}
+	a.free = append(a.free, slot)
+	a.used--
+}
+
+// Used is how many slots hold an image
+func (a *TextureAtlas) Used() int {
+	return a.used
+}
+
+func (a *TextureAtlas) Pages() int {
+	return len(a.pages)
+}
+
+// Close deletes the atlas textures, any slots still in use become invalid
+func (a *TextureAtlas) Close() {
+	for _, texture := range a.pages {
+		a.renderer.Delete(texture)
+	}
+	a.pages = nil
+	a.free = nil
+	a.used = 0
+}
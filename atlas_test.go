@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func uniformImage(size int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Rect, &image.Uniform{C: c}, image.Point{}, draw.Src)
+
+	return img
+}
+
+func TestTextureAtlas_Add(t *testing.T) {
+	renderer := NewSoftwareRenderer(64, 64)
+	atlas := NewTextureAtlas(renderer, 128, 64)
+
+	slots := []AtlasSlot{}
+	for i := 0; i < 5; i++ {
+		slot, err := atlas.Add(uniformImage(64, color.RGBA{R: uint8(i), A: 255}))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		slots = append(slots, slot)
+	}
+
+	// Four slots fit on a page, so the fifth starts another
+	if atlas.Pages() != 2 || atlas.Used() != 5 || renderer.Textures() != 2 {
+		t.Errorf("unexpected atlas, %d pages %d used", atlas.Pages(), atlas.Used())
+	}
+	if slots[0].Texture != slots[3].Texture || slots[0].Texture == slots[4].Texture {
+		t.Errorf("slots not packed onto pages %v", slots)
+	}
+
+	// Drawing a slot only shows its own image
+	for i, slot := range slots {
+		renderer.Clear()
+		renderer.Draw(slot.Texture, Rect{W: 64, H: 64}, slot.Src)
+		for _, p := range []image.Point{{0, 0}, {63, 0}, {0, 63}, {63, 63}, {32, 32}} {
+			if got := renderer.Frame.RGBAAt(p.X, p.Y); got.R != uint8(i) {
+				t.Errorf("slot %d at %v: unexpected pixel %v", i, p, got)
+			}
+		}
+	}
+
+	atlas.Remove(slots[1])
+	slot, err := atlas.Add(uniformImage(64, color.RGBA{R: 10, A: 255}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if slot.Texture != slots[1].Texture || slot.Src != slots[1].Src || atlas.Pages() != 2 {
+		t.Errorf("freed slot not reused, got %v", slot)
+	}
+
+	atlas.Close()
+	if renderer.Textures() != 0 {
+		t.Errorf("%d textures left after close", renderer.Textures())
+	}
+}
+
+func TestTextureAtlas_LargeImage(t *testing.T) {
+	renderer := NewSoftwareRenderer(64, 64)
+	atlas := NewTextureAtlas(renderer, 128, 64)
+
+	slot, err := atlas.Add(uniformImage(100, color.RGBA{A: 255}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if atlas.Pages() != 0 || slot.Src != FullTexture || renderer.Textures() != 1 {
+		t.Errorf("large image was put in the atlas")
+	}
+
+	atlas.Remove(slot)
+	if renderer.Textures() != 0 {
+		t.Errorf("large image texture not deleted")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"cartog/tile"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const CONFIG_FILE = "config.json"
+
+// ProviderConfig is a named tile provider profile from the config file
+type ProviderConfig struct {
+	// URL is a tile server URL template, or the path of an MBTiles or PMTiles
+	// archive to view offline
+	URL         string            `json:"url"`
+	Subdomains  []string          `json:"subdomains,omitempty"`
+	Retina      bool              `json:"retina,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Attribution string            `json:"attribution,omitempty"`
+	MinZoom     uint32            `json:"min_zoom,omitempty"`
+	MaxZoom     uint32            `json:"max_zoom,omitempty"`
+	TileSize    uint32            `json:"tile_size,omitempty"`
+}
+
+// Config is everything about how cartog starts up, read from the config file
+// and then overridden by any command line flags.
+type Config struct {
+	// Provider is the name of a profile in Providers, or otherwise a URL
+	// template or archive path. Empty means OpenStreetMap.
+	Provider string `json:"provider,omitempty"`
+	// CacheDir holds a tile cache directory for each provider
+	CacheDir   string  `json:"cache_dir,omitempty"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Zoom       float64 `json:"zoom"`
+	Width      uint32  `json:"width,omitempty"`
+	Height     uint32  `json:"height,omitempty"`
+	Fullscreen bool    `json:"fullscreen,omitempty"`
+	// Renderer is one of RENDERERS, empty picks the first which works
+	Renderer string `json:"renderer,omitempty"`
+	// FetchWorkers is how many tiles are fetched at once
+	FetchWorkers int `json:"fetch_workers,omitempty"`
+	// ZoomDurationMs is how long zooming in or out takes, 0 zooms instantly
+	ZoomDurationMs int `json:"zoom_duration_ms"`
+	// FlingFriction is how quickly the map slows down after being flung,
+	// 0 stops it dead when let go
+	FlingFriction float64                   `json:"fling_friction"`
+	Providers     map[string]ProviderConfig `json:"providers,omitempty"`
+	// KeyBindings replace the default keys of each action listed, an empty
+	// list leaving the action unbound
+	KeyBindings map[Action][]string `json:"key_bindings,omitempty"`
+	// PrintKeyBindings lists the key bindings instead of opening the map
+	PrintKeyBindings bool `json:"-"`
+	// RecordPath is a file to record the session to, for "cartog replay"
+	RecordPath string `json:"-"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Lat:            START_LAT,
+		Lon:            START_LON,
+		Zoom:           START_ZOOM,
+		FetchWorkers:   DEFAULT_FETCH_WORKERS,
+		ZoomDurationMs: DEFAULT_ZOOM_DURATION_MS,
+		FlingFriction:  DEFAULT_FLING_FRICTION,
+	}
+}
+
+// DefaultConfigPath is the config file under the XDG config directory (or
+// the platform equivalent)
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "cartog", CONFIG_FILE), nil
+}
+
+// LoadConfig reads the config file at path over the defaults, a missing file
+// just leaves the defaults.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// parseFlags reads the config file then applies the command line over it, so
+// only flags which were actually given replace what is in the file.
+func parseFlags(name string, args []string) (*Config, error) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] [provider]\n", name)
+		flags.PrintDefaults()
+	}
+
+	defaults := DefaultConfig()
+	configPath := flags.String("config", "", "config file, defaults to "+CONFIG_FILE+" in the user config directory")
+	lat := flags.Float64("lat", defaults.Lat, "latitude to start centred on")
+	lon := flags.Float64("lon", defaults.Lon, "longitude to start centred on")
+	zoom := flags.Float64("zoom", defaults.Zoom, "zoom level to start at")
+	provider := flags.String("provider", "", "provider profile name, tile server URL template or MBTiles/PMTiles file")
+	cacheDir := flags.String("cache-dir", "", "directory to cache tiles in")
+	fullscreen := flags.Bool("fullscreen", false, "start fullscreen")
+	fetchWorkers := flags.Int("fetch-workers", defaults.FetchWorkers, "number of tiles to fetch at once")
+	zoomDuration := flags.Int("zoom-duration", defaults.ZoomDurationMs, "milliseconds zooming in or out takes, 0 zooms instantly")
+	flingFriction := flags.Float64("fling-friction", defaults.FlingFriction, "how quickly the map slows down after being flung, 0 turns flinging off")
+	printKeyBindings := flags.Bool("print-keybindings", false, "list the keys bound to each action and exit")
+	record := flags.String("record", "", "file to record the movements of the map to, for cartog replay")
+	renderer := flags.String("renderer", "", "renderer to use, one of "+strings.Join(RENDERERS, ", ")+", defaults to the first which works")
+	flags.Parse(args)
+
+	path := *configPath
+	if path == "" {
+		var err error
+		if path, err = DefaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider may also be given on its own, as in earlier versions
+	if flags.NArg() > 0 {
+		config.Provider = flags.Arg(0)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "lat":
+			config.Lat = *lat
+		case "lon":
+			config.Lon = *lon
+		case "zoom":
+			config.Zoom = *zoom
+		case "provider":
+			config.Provider = *provider
+		case "cache-dir":
+			config.CacheDir = *cacheDir
+		case "fullscreen":
+			config.Fullscreen = *fullscreen
+		case "renderer":
+			config.Renderer = *renderer
+		case "fetch-workers":
+			config.FetchWorkers = *fetchWorkers
+		case "zoom-duration":
+			config.ZoomDurationMs = *zoomDuration
+		case "fling-friction":
+			config.FlingFriction = *flingFriction
+		case "print-keybindings":
+			config.PrintKeyBindings = *printKeyBindings
+		case "record":
+			config.RecordPath = *record
+		}
+	})
+
+	return config, nil
+}
+
+// Keymap binds keys to actions, the defaults with the config file's bindings
+// over them
+func (c *Config) Keymap() (*Keymap, error) {
+	bindings := DefaultKeyBindings()
+	for action, keys := range c.KeyBindings {
+		bindings[action] = keys
+	}
+
+	return NewKeymap(bindings)
+}
+
+// ProviderProfile resolves name to a provider profile, treating names without
+// one as a URL template or archive path. The name tiles are cached under is
+// returned with it, which changes along with the profile's URL so that tiles
+// from an earlier provider are not shown.
+func (c *Config) ProviderProfile(name string) (ProviderConfig, string) {
+	if profile, ok := c.Providers[name]; ok {
+		return profile, name + "-" + locationHash(profile.URL)
+	}
+
+	return ProviderConfig{URL: name}, cacheName(name)
+}
+
+// TileCacheDir is the directory tiles of the named provider are cached in
+func (c *Config) TileCacheDir(name string) (string, error) {
+	if c.CacheDir != "" {
+		return filepath.Join(c.CacheDir, name), nil
+	}
+
+	return tile.DefaultCacheDir(name)
+}
+
+// openProvider opens the tile source of a profile, without any caching
+func openProvider(profile ProviderConfig) (tile.TileSource, error) {
+	var source tile.TileSource
+	var err error
+	if strings.Contains(profile.URL, "://") {
+		// A template using {s} cannot be opened without its subdomains
+		source, err = tile.NewTileDatasource(profile.URL, profile.Subdomains...)
+	} else {
+		source, err = tile.OpenSource(profile.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ds, remote := source.(*tile.TileDatasource)
+	if !remote {
+		return source, nil
+	}
+
+	// The default data source is shared, so customise a copy
+	custom := *ds
+	custom.Subdomains = profile.Subdomains
+	custom.Retina = profile.Retina
+	if len(profile.Params) > 0 {
+		custom.Params = url.Values{}
+		for key, value := range profile.Params {
+			custom.Params.Set(key, value)
+		}
+	}
+	if profile.Attribution != "" {
+		custom.Attribution = profile.Attribution
+	}
+	if profile.MinZoom != 0 {
+		custom.MinZoom = profile.MinZoom
+	}
+	if profile.MaxZoom != 0 {
+		custom.MaxZoom = profile.MaxZoom
+	}
+	if profile.TileSize != 0 {
+		custom.TileSize = profile.TileSize
+	}
+
+	return &custom, nil
+}
+
+// cacheName is the name of the directory tiles from location are cached in.
+// Tile servers are named by their host, followed by a hash of the whole URL
+// template as a server may have several styles or resolutions.
+func cacheName(location string) string {
+	if location == "" {
+		return "default"
+	}
+
+	name := location
+	if u, err := url.Parse(location); err == nil && u.Host != "" {
+		name = strings.TrimPrefix(u.Host, "{s}.") + "-" + locationHash(location)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == '{' || r == '}' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// locationHash is a short hash of a tile server URL template or archive path
+func locationHash(location string) string {
+	sum := sha256.Sum256([]byte(location))
+
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"cartog/tile"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `{
+	"provider": "topo",
+	"lat": -41.2865,
+	"lon": 174.7762,
+	"zoom": 10,
+	"providers": {
+		"topo": {
+			"url": "https://{s}.tile.example.com/{z}/{x}/{y}{r}.png",
+			"subdomains": ["a", "b"],
+			"retina": true,
+			"params": {"key": "secret"},
+			"max_zoom": 17
+		}
+	}
+}`
+
+func writeTestConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig(writeTestConfig(t))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if config.Provider != "topo" || config.Zoom != 10 || config.Lat != -41.2865 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	profile, name := config.ProviderProfile(config.Provider)
+	if !strings.HasPrefix(name, "topo-") {
+		t.Errorf("expected a cache name for topo, got %s", name)
+	}
+	// Changing the profile's URL moves its cache
+	config.Providers["topo"] = ProviderConfig{URL: "https://tiles.example.com/{z}/{x}/{y}.png"}
+	if _, moved := config.ProviderProfile("topo"); moved == name || !strings.HasPrefix(moved, "topo-") {
+		t.Errorf("expected a new cache name for the new URL, got %s and %s", name, moved)
+	}
+	source, err := openProvider(profile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ds, ok := source.(*tile.TileDatasource)
+	if !ok {
+		t.Fatalf("expected a tile server, got %T", source)
+	}
+	if !ds.Retina || len(ds.Subdomains) != 2 || ds.Params.Get("key") != "secret" || ds.Info().MaxZoom != 17 {
+		t.Errorf("profile not applied: %+v", ds)
+	}
+	if tile.DefaultTileDatasource.MaxZoom == 17 {
+		t.Errorf("default data source was modified")
+	}
+}
+
+func TestLoadConfig_Missing(t *testing.T) {
+	config, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if config.Lat != START_LAT || config.Lon != START_LON || config.Zoom != START_ZOOM {
+		t.Errorf("expected defaults, got %+v", config)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	path := writeTestConfig(t)
+
+	config, err := parseFlags("cartog", []string{"-config", path, "--zoom", "5", "--cache-dir", "/tmp/tiles", "--renderer", RENDERER_GL21})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// Flags override the file, anything else comes from the file
+	if config.Zoom != 5 || config.Lat != -41.2865 || config.Provider != "topo" || config.Renderer != RENDERER_GL21 {
+		t.Errorf("flags not applied over config file: %+v", config)
+	}
+	if dir, _ := config.TileCacheDir("topo"); dir != filepath.Join("/tmp/tiles", "topo") {
+		t.Errorf("unexpected cache dir %s", dir)
+	}
+
+	config, err = parseFlags("cartog", []string{"-config", path, "https://tiles.example.com/{z}/{x}/{y}.png"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	profile, name := config.ProviderProfile(config.Provider)
+	if profile.URL != "https://tiles.example.com/{z}/{x}/{y}.png" || !strings.HasPrefix(name, "tiles.example.com-") {
+		t.Errorf("unexpected provider %+v %s", profile, name)
+	}
+}
+
+func TestCacheName(t *testing.T) {
+	styleA := cacheName("https://tiles.example.com/styles/a/{z}/{x}/{y}.png")
+	styleB := cacheName("https://tiles.example.com/styles/b/{z}/{x}/{y}.png")
+	retina := cacheName("https://tiles.example.com/styles/a/{z}/{x}/{y}@2x.png")
+	if styleA == styleB || styleA == retina {
+		t.Errorf("templates on the same host share a cache: %s, %s, %s", styleA, styleB, retina)
+	}
+	if !strings.HasPrefix(styleA, "tiles.example.com-") || strings.ContainsAny(styleA, "/:{}") {
+		t.Errorf("unexpected cache name %s", styleA)
+	}
+	if again := cacheName("https://tiles.example.com/styles/a/{z}/{x}/{y}.png"); again != styleA {
+		t.Errorf("cache name changed from %s to %s", styleA, again)
+	}
+}
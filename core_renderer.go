@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+var _ Renderer = (*CoreRenderer)(nil)
+
+const (
+	coreVertexShader = `
+#version 330 core
+uniform vec2 viewSize;
+layout(location = 0) in vec2 position;
+layout(location = 1) in vec2 texCoord;
+out vec2 fragTexCoord;
+
+void main() {
+	fragTexCoord = texCoord;
+	vec2 clip = position / viewSize * 2.0 - 1.0;
+	gl_Position = vec4(clip.x, -clip.y, 0.0, 1.0);
+}
+` + "\x00"
+
+	coreFragmentShader = `
+#version 330 core
+uniform sampler2D tex;
+in vec2 fragTexCoord;
+out vec4 color;
+
+void main() {
+	color = texture(tex, fragTexCoord);
+}
+` + "\x00"
+
+	corePositionAttrib = 0
+	coreTexCoordAttrib = 1
+)
+
+// CoreRenderer draws with shaders, a vertex buffer and a vertex array object
+// in an OpenGL 3.3 core profile context, for drivers which have dropped the
+// fixed function pipeline such as on macOS. Quads are batched as the
+// GLESRenderer does.
+type CoreRenderer struct {
+	width       float32
+	height      float32
+	program     uint32
+	vertexArray uint32
+	buffer      uint32
+	viewSize    int32
+	quads       quadBatch
+}
+
+func NewCoreRenderer(width, height uint32) (*CoreRenderer, error) {
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+
+	program, err := linkCoreProgram(coreVertexShader, coreFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer := &CoreRenderer{
+		program:  program,
+		viewSize: gl.GetUniformLocation(program, gl.Str("viewSize\x00")),
+	}
+	gl.UseProgram(program)
+	// Go images have premultiplied alpha
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Uniform1i(gl.GetUniformLocation(program, gl.Str("tex\x00")), 0)
+
+	// The layout of the buffer is kept in the vertex array, so only the
+	// data needs uploading each frame
+	gl.GenVertexArrays(1, &renderer.vertexArray)
+	gl.BindVertexArray(renderer.vertexArray)
+	gl.GenBuffers(1, &renderer.buffer)
+	gl.BindBuffer(gl.ARRAY_BUFFER, renderer.buffer)
+	stride := int32(quadVertexFloats * 4)
+	gl.EnableVertexAttribArray(corePositionAttrib)
+	gl.VertexAttribPointer(corePositionAttrib, 2, gl.FLOAT, false, stride, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(coreTexCoordAttrib)
+	gl.VertexAttribPointer(coreTexCoordAttrib, 2, gl.FLOAT, false, stride, gl.PtrOffset(2*4))
+	renderer.Resize(width, height)
+
+	return renderer, nil
+}
+
+func compileCoreShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+	csource, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, csource, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+		gl.DeleteShader(shader)
+
+		return 0, fmt.Errorf("failed to compile shader: %s", strings.TrimRight(log, "\x00"))
+	}
+
+	return shader, nil
+}
+
+func linkCoreProgram(vertexSource, fragmentSource string) (uint32, error) {
+	vertexShader, err := compileCoreShader(vertexSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(vertexShader)
+	fragmentShader, err := compileCoreShader(fragmentSource, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(fragmentShader)
+
+	program := gl.CreateProgram()
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
+	gl.LinkProgram(program)
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+		gl.DeleteProgram(program)
+
+		return 0, fmt.Errorf("failed to link program: %s", strings.TrimRight(log, "\x00"))
+	}
+
+	return program, nil
+}
+
+func (r *CoreRenderer) Upload(img image.Image) (Texture, error) {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return 0, err
+	}
+
+	texture := r.newTexture()
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RGBA8,
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		0,
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(rgba.Pix))
+
+	return Texture(texture), nil
+}
+
+func (r *CoreRenderer) NewTexture(width, height int) (Texture, error) {
+	texture := r.newTexture()
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RGBA8,
+		int32(width),
+		int32(height),
+		0,
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		nil)
+
+	return Texture(texture), nil
+}
+
+func (r *CoreRenderer) UploadRegion(texture Texture, x, y int, img image.Image) error {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return err
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, uint32(texture))
+	gl.TexSubImage2D(
+		gl.TEXTURE_2D,
+		0,
+		int32(x),
+		int32(y),
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(rgba.Pix))
+
+	return nil
+}
+
+// newTexture creates and binds a texture, leaving its contents to be set
+func (r *CoreRenderer) newTexture() uint32 {
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	return texture
+}
+
+// Draw queues the quad to be drawn when the frame is flushed
+func (r *CoreRenderer) Draw(texture Texture, dst Rect, src Rect) {
+	r.quads.Add(texture, dst, src)
+}
+
+func (r *CoreRenderer) SetRotation(rotation Rotation) {
+	r.quads.rotation = rotation
+}
+
+// Flush uploads all quads of the frame in one go then draws them
+func (r *CoreRenderer) Flush() {
+	if len(r.quads.runs) == 0 {
+		return
+	}
+
+	gl.UseProgram(r.program)
+	gl.Uniform2f(r.viewSize, r.width, r.height)
+
+	gl.BindVertexArray(r.vertexArray)
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, len(r.quads.vertices)*4, gl.Ptr(r.quads.vertices), gl.STREAM_DRAW)
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	for _, run := range r.quads.runs {
+		gl.BindTexture(gl.TEXTURE_2D, uint32(run.texture))
+		gl.DrawArrays(gl.TRIANGLES, run.first, run.count)
+	}
+
+	r.quads.Reset()
+}
+
+func (r *CoreRenderer) Delete(texture Texture) {
+	handle := uint32(texture)
+	gl.DeleteTextures(1, &handle)
+}
+
+func (r *CoreRenderer) Clear() {
+	r.quads.Reset()
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+}
+
+func (r *CoreRenderer) Resize(width, height uint32) {
+	r.width = float32(width)
+	r.height = float32(height)
+	gl.Viewport(0, 0, int32(width), int32(height))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"cartog/tile"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseZoomRange reads a single zoom level "12" or an inclusive range "10-14"
+func parseZoomRange(s string) (uint32, uint32, error) {
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid zoom %q", s)
+	}
+	max := min
+	if len(parts) == 2 {
+		max, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid zoom %q", s)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("invalid zoom range %q", s)
+	}
+
+	return uint32(min), uint32(max), nil
+}
+
+// runDownload implements "cartog download", which fetches every tile of a
+// region into the tile cache or an MBTiles file for use while offline.
+func runDownload(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	bbox := flags.String("bbox", "", "region to download as min_lon,min_lat,max_lon,max_lat")
+	zoom := flags.String("zoom", "", "zoom level or range to download, e.g. 10-14")
+	provider := flags.String("provider", "", "provider profile name or tile server URL template, defaults to OpenStreetMap")
+	output := flags.String("o", "", "MBTiles file to write to, defaults to the tile cache")
+	concurrency := flags.Int("concurrency", tile.DefaultDownloadConcurrency, "number of tiles to fetch at once")
+	maxTiles := flags.Int("max-tiles", tile.DefaultMaxDownloadTiles, "refuse to download regions with more tiles than this")
+	estimate := flags.Bool("estimate", false, "only print the size of the download")
+	flags.Parse(args)
+
+	if *bbox == "" || *zoom == "" {
+		flags.Usage()
+		return errors.New("both -bbox and -zoom are required")
+	}
+	bounds, err := tile.ParseBounds(*bbox)
+	if err != nil {
+		return err
+	}
+	minZoom, maxZoom, err := parseZoomRange(*zoom)
+	if err != nil {
+		return err
+	}
+	region := tile.Region{
+		Bounds:  bounds,
+		MinZoom: minZoom,
+		MaxZoom: maxZoom,
+	}
+
+	configPath, err := DefaultConfigPath()
+	if err != nil {
+		return err
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	profile, name := config.ProviderProfile(*provider)
+	source, err := openProvider(profile)
+	if err != nil {
+		return err
+	}
+	if _, remote := source.(*tile.TileDatasource); !remote {
+		return fmt.Errorf("%s is not a tile server", *provider)
+	}
+
+	var writer tile.TileWriter
+	if *output != "" {
+		info := source.Info()
+		info.Bounds = bounds
+		info.MinZoom = minZoom
+		info.MaxZoom = maxZoom
+		mbtiles, err := tile.CreateMBTiles(*output, name, info)
+		if err != nil {
+			return err
+		}
+		defer mbtiles.Close()
+		writer = mbtiles
+	} else {
+		cacheDir, err := config.TileCacheDir(name)
+		if err != nil {
+			return err
+		}
+		cache, err := tile.NewDiskCache(source, cacheDir)
+		if err != nil {
+			return err
+		}
+		writer = cache
+	}
+
+	lastReport := time.Time{}
+	downloader := &tile.Downloader{
+		Source:      source,
+		Writer:      writer,
+		Concurrency: *concurrency,
+		MaxTiles:    *maxTiles,
+		Progress: func(p tile.DownloadProgress) {
+			if time.Since(lastReport) < time.Second && p.Done() != p.Total {
+				return
+			}
+			lastReport = time.Now()
+			log.Printf("%d/%d tiles (%d downloaded, %d already present, %d failed), %d KiB",
+				p.Done(), p.Total, p.Downloaded, p.Skipped, p.Failed, p.Bytes/1024)
+		},
+	}
+
+	missing, size, err := downloader.Estimate(region)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d tiles to download, roughly %.1f MiB\n", missing, region.TileCount(), float64(size)/(1024*1024))
+	if *estimate {
+		return nil
+	}
+
+	// Interrupting leaves what has been downloaded so far for a later resume
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	progress, err := downloader.Download(ctx, region)
+	if err != nil && progress.Failed > 0 {
+		return fmt.Errorf("%d tiles failed to download, run again to retry them: %w", progress.Failed, err)
+	}
+
+	return err
+}
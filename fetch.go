@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cartog/tile"
+	"container/heap"
+	"sync"
+)
+
+// DEFAULT_FETCH_WORKERS is the most connections the OSM tile usage policy
+// allows a client to make at once
+const DEFAULT_FETCH_WORKERS = tile.DefaultDownloadConcurrency
+
+type fetchRequest struct {
+	coord    tile.TileCoord
+	priority float64
+	index    int
+}
+
+// fetchHeap implements heap.Interface, lowest priority value first
+type fetchHeap []*fetchRequest
+
+func (h fetchHeap) Len() int { return len(h) }
+
+func (h fetchHeap) Less(i, j int) bool { return h[i].priority < h[j].priority }
+
+func (h fetchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fetchHeap) Push(x interface{}) {
+	request := x.(*fetchRequest)
+	request.index = len(*h)
+	*h = append(*h, request)
+}
+
+func (h *fetchHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	request := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+
+	return request
+}
+
+// fetchQueue holds the tiles waiting for a fetch worker, handing out the
+// most important one first
+type fetchQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	requests fetchHeap
+	queued   map[tile.TileCoord]*fetchRequest
+	closed   bool
+}
+
+func newFetchQueue() *fetchQueue {
+	q := &fetchQueue{
+		queued: map[tile.TileCoord]*fetchRequest{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// Push queues the tile, or updates its priority if it is already queued
+func (q *fetchQueue) Push(coord tile.TileCoord, priority float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if request, exists := q.queued[coord]; exists {
+		request.priority = priority
+		heap.Fix(&q.requests, request.index)
+		return
+	}
+
+	request := &fetchRequest{
+		coord:    coord,
+		priority: priority,
+	}
+	heap.Push(&q.requests, request)
+	q.queued[coord] = request
+	q.cond.Signal()
+}
+
+// Pop waits for a tile to fetch, returning false once the queue is closed
+func (q *fetchQueue) Pop() (tile.TileCoord, bool) {
+	return q.PopWith(nil)
+}
+
+// PopWith is Pop, calling taken with the tile, if given, before it is
+// unlocked so that nothing sees the tile in neither the queue nor taken's
+// bookkeeping
+func (q *fetchQueue) PopWith(taken func(coord tile.TileCoord)) (tile.TileCoord, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.requests) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return tile.TileCoord{}, false
+	}
+
+	request := heap.Pop(&q.requests).(*fetchRequest)
+	delete(q.queued, request.coord)
+	if taken != nil {
+		taken(request.coord)
+	}
+
+	return request.coord, true
+}
+
+// Retain reprioritises the queued tiles, dropping those which keep says are
+// no longer wanted. The dropped tiles are returned.
+func (q *fetchQueue) Retain(keep func(coord tile.TileCoord) (float64, bool)) []tile.TileCoord {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := []tile.TileCoord{}
+	kept := q.requests[:0]
+	for _, request := range q.requests {
+		priority, ok := keep(request.coord)
+		if !ok {
+			dropped = append(dropped, request.coord)
+			delete(q.queued, request.coord)
+			continue
+		}
+		request.priority = priority
+		kept = append(kept, request)
+	}
+	for i := len(kept); i < len(q.requests); i++ {
+		q.requests[i] = nil
+	}
+	q.requests = kept
+	for i, request := range q.requests {
+		request.index = i
+	}
+	heap.Init(&q.requests)
+
+	return dropped
+}
+
+// Clear drops every queued tile
+func (q *fetchQueue) Clear() []tile.TileCoord {
+	return q.Retain(func(tile.TileCoord) (float64, bool) {
+		return 0, false
+	})
+}
+
+func (q *fetchQueue) Contains(coord tile.TileCoord) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, exists := q.queued[coord]
+	return exists
+}
+
+func (q *fetchQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.requests)
+}
+
+// Close wakes up and stops all workers waiting on the queue
+func (q *fetchQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
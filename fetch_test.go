@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cartog/tile"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFetchQueue_Priority(t *testing.T) {
+	q := newFetchQueue()
+	for x := uint32(0); x < 5; x++ {
+		q.Push(tile.TileCoord{X: x, Z: 3}, float64(5-x))
+	}
+	// Reprioritising moves a queued tile rather than adding it again
+	q.Push(tile.TileCoord{X: 0, Z: 3}, 0.5)
+	if q.Len() != 5 {
+		t.Errorf("expected 5 queued tiles, got %d", q.Len())
+	}
+
+	expected := []uint32{0, 4, 3, 2, 1}
+	for _, x := range expected {
+		coord, ok := q.Pop()
+		if !ok || coord.X != x {
+			t.Errorf("expected tile %d next, got %v", x, coord)
+		}
+	}
+}
+
+func TestFetchQueue_Retain(t *testing.T) {
+	q := newFetchQueue()
+	for x := uint32(0); x < 6; x++ {
+		q.Push(tile.TileCoord{X: x, Z: 3}, float64(x))
+	}
+
+	// Keep the even tiles, in reverse order
+	dropped := q.Retain(func(coord tile.TileCoord) (float64, bool) {
+		return -float64(coord.X), coord.X%2 == 0
+	})
+	if len(dropped) != 3 || q.Len() != 3 || q.Contains(tile.TileCoord{X: 1, Z: 3}) {
+		t.Errorf("unexpected tiles dropped %v", dropped)
+	}
+	for _, x := range []uint32{4, 2, 0} {
+		if coord, _ := q.Pop(); coord.X != x {
+			t.Errorf("expected tile %d next, got %v", x, coord)
+		}
+	}
+}
+
+func TestFetchQueue_Close(t *testing.T) {
+	q := newFetchQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Pop()
+		done <- ok
+	}()
+
+	q.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("expected closed queue")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("worker not woken by close")
+	}
+}
+
+func TestTileGrid_FetchQueue(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{X: 1152, Y: 1152, Z: 4}, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// The tile in the middle of the view is fetched first
+	coord, _ := grid.queue.Pop()
+	if coord != (tile.TileCoord{X: 5, Y: 5, Z: 4}) {
+		t.Errorf("expected the middle tile first, got %v", coord)
+	}
+
+	// Moving away drops the tiles which are no longer visible
+	corner := tile.TileCoord{X: 3, Y: 3, Z: 4}
+	if !grid.queue.Contains(corner) {
+		t.Fatalf("expected %v to be queued", corner)
+	}
+	grid.Move(Coord{X: 1024, Y: 1024})
+	if grid.queue.Contains(corner) || grid.IsLoading(corner) {
+		t.Errorf("tile which left the view is still queued")
+	}
+	if !grid.queue.Contains(tile.TileCoord{X: 9, Y: 9, Z: 4}) {
+		t.Errorf("newly visible tile not queued")
+	}
+}
+
+// blockingSource holds every fetch until it is cancelled
+type blockingSource struct {
+	testSource
+	started chan tile.TileCoord
+}
+
+func (s *blockingSource) Tile(ctx context.Context, coord tile.TileCoord) (*tile.PngTile, error) {
+	s.started <- coord
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+func TestTileGrid_CancelFetch(t *testing.T) {
+	source := &blockingSource{*newTestSource(), make(chan tile.TileCoord)}
+	grid, err := NewTileGrid(source, Coord{X: 1152, Y: 1152, Z: 4}, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer grid.Close()
+
+	fetched := make(chan tile.TileCoord, 1)
+	go func() {
+		var fetch *tileFetch
+		coord, _ := grid.queue.PopWith(func(coord tile.TileCoord) {
+			fetch = grid.startFetch(coord)
+		})
+		grid.fetchTile(coord, fetch)
+		fetched <- coord
+	}()
+
+	coord := <-source.started
+	if inFlight := grid.InFlight(); len(inFlight) != 1 || inFlight[0] != coord {
+		t.Errorf("expected %v in flight, got %v", coord, inFlight)
+	}
+
+	// Tiles still in view keep loading
+	grid.Move(Coord{X: 16, Y: 16})
+	select {
+	case <-fetched:
+		t.Fatalf("visible tile %v was cancelled", coord)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Only the tile which left the view is cancelled
+	grid.Move(Coord{X: 1024, Y: 1024})
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatalf("fetch of %v not cancelled", coord)
+	}
+	if len(grid.InFlight()) != 0 || grid.IsLoading(coord) {
+		t.Errorf("cancelled tile %v still loading", coord)
+	}
+}
+
+// failingSource fails every fetch
+type failingSource struct {
+	testSource
+}
+
+func (s *failingSource) Tile(_ context.Context, _ tile.TileCoord) (*tile.PngTile, error) {
+	return nil, errors.New("server unavailable")
+}
+
+func TestTileGrid_FailedFetch(t *testing.T) {
+	grid, err := NewTileGrid(&failingSource{*newTestSource()}, Coord{X: 1152, Y: 1152, Z: 4}, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	coord, _ := grid.queue.Pop()
+	if _, err := grid.FetchTile(coord); err == nil {
+		t.Fatalf("expected %v to fail", coord)
+	}
+	if grid.IsLoading(coord) || len(grid.InFlight()) != 0 {
+		t.Errorf("failed tile %v still loading", coord)
+	}
+
+	// The tile is left a while before it is asked for again
+	grid.Move(Coord{})
+	if grid.queue.Contains(coord) || grid.IsLoading(coord) {
+		t.Errorf("failed tile %v queued again straight away", coord)
+	}
+	grid.inFlightMu.Lock()
+	failure := grid.failed[coord]
+	if failure == nil || failure.delay != FETCH_RETRY_DELAY {
+		t.Fatalf("unexpected retry of failed tile %v: %+v", coord, failure)
+	}
+	failure.retryAt = time.Now().Add(-time.Second)
+	grid.inFlightMu.Unlock()
+	grid.Move(Coord{})
+	if !grid.queue.Contains(coord) {
+		t.Errorf("failed tile %v not queued again", coord)
+	}
+
+	// Failing again waits longer, and missing tiles wait longest
+	grid.queue.Clear()
+	grid.FetchTile(coord)
+	grid.inFlightMu.Lock()
+	if delay := grid.failed[coord].delay; delay != 2*FETCH_RETRY_DELAY {
+		t.Errorf("expected %s before retrying, got %s", 2*FETCH_RETRY_DELAY, delay)
+	}
+	grid.fetchFailed(coord, tile.ErrTileNotFound, time.Now())
+	if delay := grid.failed[coord].delay; delay != FETCH_RETRY_MAX_DELAY {
+		t.Errorf("expected %s before retrying a missing tile, got %s", FETCH_RETRY_MAX_DELAY, delay)
+	}
+	grid.inFlightMu.Unlock()
+}
@@ -0,0 +1,336 @@
+// Package gesture recognises map gestures such as panning, pinching and
+// double tapping from raw touch points, whatever the platform they came from.
+package gesture
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// TapSlop is how far in pixels a finger may wander before a touch
+	// counts as a pan rather than a tap or long press
+	TapSlop = 10.0
+	// DoubleTapSlop is how far apart two taps may be to count as a double tap
+	DoubleTapSlop = 30.0
+	// DoubleTapInterval is the longest time between two taps of a double tap
+	DoubleTapInterval = 300 * time.Millisecond
+	// LongPressDuration is how long a finger is held still for a long press
+	LongPressDuration = 500 * time.Millisecond
+	// RotateThreshold is how far in radians two fingers turn before
+	// rotating, so that pinching does not turn the map by accident
+	RotateThreshold = 10.0 * math.Pi / 180.0
+)
+
+// Phase is what happened to a touch point
+type Phase int
+
+const (
+	TouchDown Phase = iota
+	TouchMove
+	TouchUp
+	// TouchCancel ends a touch without it counting as a tap or fling, as
+	// when the system takes over the touch
+	TouchCancel
+)
+
+// TouchEvent is a touch point, in pixels from the top left of the view,
+// identified by ID for as long as the finger stays down
+type TouchEvent struct {
+	ID    int
+	Phase Phase
+	X     float32
+	Y     float32
+	Time  time.Time
+}
+
+// Kind is the gesture recognised
+type Kind int
+
+const (
+	// Press is a finger coming down with no others on the screen
+	Press Kind = iota
+	// Pan moves the fingers by DX, DY
+	Pan
+	// Fling lets go of a pan moving at VelocityX, VelocityY pixels a second
+	Fling
+	// Pinch scales the distance between two fingers by Scale about X, Y
+	Pinch
+	// Rotate turns two fingers by Rotation radians clockwise about X, Y
+	Rotate
+	// Tap is a finger put down and lifted at X, Y without moving
+	Tap
+	// DoubleTap is a second tap at X, Y soon after the first, in place of
+	// a Tap
+	DoubleTap
+	// LongPress holds a finger still at X, Y
+	LongPress
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Press:
+		return "press"
+	case Pan:
+		return "pan"
+	case Fling:
+		return "fling"
+	case Pinch:
+		return "pinch"
+	case Rotate:
+		return "rotate"
+	case Tap:
+		return "tap"
+	case DoubleTap:
+		return "double tap"
+	case LongPress:
+		return "long press"
+	}
+
+	return "unknown"
+}
+
+// Event is a recognised gesture. X, Y is where it happened, the point between
+// the fingers when there are two.
+type Event struct {
+	Kind      Kind
+	Time      time.Time
+	X         float32
+	Y         float32
+	DX        float32
+	DY        float32
+	Scale     float32
+	Rotation  float32
+	VelocityX float32
+	VelocityY float32
+}
+
+type touchPoint struct {
+	id int
+	x  float32
+	y  float32
+}
+
+// Recognizer turns touch events into gestures, handing each to the handler
+// as soon as it is recognised. It is not safe for concurrent use.
+type Recognizer struct {
+	handler func(Event)
+	touches []touchPoint
+
+	// The single finger gesture under way
+	downAt      time.Time
+	downX       float32
+	downY       float32
+	lastX       float32
+	lastY       float32
+	panning     bool
+	multiTouch  bool
+	longPressed bool
+	velocity    velocityTracker
+
+	// The two finger gesture under way
+	centerX  float32
+	centerY  float32
+	distance float32
+	angle    float32
+	rotating bool
+	rotation float32
+
+	lastTap  time.Time
+	lastTapX float32
+	lastTapY float32
+}
+
+func NewRecognizer(handler func(Event)) *Recognizer {
+	return &Recognizer{
+		handler: handler,
+	}
+}
+
+// Touch feeds the recogniser the next touch event
+func (r *Recognizer) Touch(event TouchEvent) {
+	switch event.Phase {
+	case TouchDown:
+		r.touchDown(event)
+	case TouchMove:
+		r.touchMove(event)
+	case TouchUp, TouchCancel:
+		r.touchUp(event)
+	}
+}
+
+// Update recognises gestures which happen without the fingers moving, such
+// as a long press, so it should be called every frame
+func (r *Recognizer) Update(now time.Time) {
+	if len(r.touches) != 1 || r.panning || r.multiTouch || r.longPressed {
+		return
+	}
+	if now.Sub(r.downAt) < LongPressDuration {
+		return
+	}
+
+	r.longPressed = true
+	r.emit(Event{Kind: LongPress, Time: now, X: r.lastX, Y: r.lastY})
+}
+
+func (r *Recognizer) emit(event Event) {
+	if r.handler != nil {
+		r.handler(event)
+	}
+}
+
+func (r *Recognizer) find(id int) int {
+	for i, touch := range r.touches {
+		if touch.id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (r *Recognizer) touchDown(event TouchEvent) {
+	if r.find(event.ID) >= 0 {
+		return
+	}
+
+	if len(r.touches) == 0 {
+		r.downAt = event.Time
+		r.downX, r.downY = event.X, event.Y
+		r.lastX, r.lastY = event.X, event.Y
+		r.panning = false
+		r.multiTouch = false
+		r.longPressed = false
+		r.velocity.Reset(event.Time)
+		r.emit(Event{Kind: Press, Time: event.Time, X: event.X, Y: event.Y})
+	}
+
+	r.touches = append(r.touches, touchPoint{id: event.ID, x: event.X, y: event.Y})
+	if len(r.touches) == 2 {
+		r.multiTouch = true
+		r.rotating = false
+		r.rotation = 0
+		r.startTwoFingers()
+	}
+}
+
+func (r *Recognizer) touchMove(event TouchEvent) {
+	i := r.find(event.ID)
+	if i < 0 {
+		return
+	}
+	r.touches[i].x, r.touches[i].y = event.X, event.Y
+
+	if len(r.touches) == 1 {
+		r.moveOneFinger(event)
+	} else if i < 2 {
+		r.moveTwoFingers(event.Time)
+	}
+}
+
+func (r *Recognizer) moveOneFinger(event TouchEvent) {
+	if !r.panning {
+		if math.Hypot(float64(event.X-r.downX), float64(event.Y-r.downY)) < TapSlop {
+			return
+		}
+		r.panning = true
+	}
+
+	dX, dY := event.X-r.lastX, event.Y-r.lastY
+	r.lastX, r.lastY = event.X, event.Y
+	r.velocity.Add(event.Time, dX, dY)
+	r.emit(Event{Kind: Pan, Time: event.Time, X: event.X, Y: event.Y, DX: dX, DY: dY})
+}
+
+// twoFingers is the point between the first two fingers, how far apart they
+// are and the angle of the line from the first to the second
+func (r *Recognizer) twoFingers() (centerX, centerY, distance, angle float32) {
+	a, b := r.touches[0], r.touches[1]
+	centerX = (a.x + b.x) / 2.0
+	centerY = (a.y + b.y) / 2.0
+	distance = float32(math.Hypot(float64(b.x-a.x), float64(b.y-a.y)))
+	angle = float32(math.Atan2(float64(b.y-a.y), float64(b.x-a.x)))
+
+	return centerX, centerY, distance, angle
+}
+
+func (r *Recognizer) startTwoFingers() {
+	r.centerX, r.centerY, r.distance, r.angle = r.twoFingers()
+}
+
+func (r *Recognizer) moveTwoFingers(now time.Time) {
+	centerX, centerY, distance, angle := r.twoFingers()
+
+	if dX, dY := centerX-r.centerX, centerY-r.centerY; dX != 0 || dY != 0 {
+		r.emit(Event{Kind: Pan, Time: now, X: centerX, Y: centerY, DX: dX, DY: dY})
+	}
+	if r.distance > 0 && distance > 0 && distance != r.distance {
+		r.emit(Event{Kind: Pinch, Time: now, X: centerX, Y: centerY, Scale: distance / r.distance})
+	}
+
+	// Keep the turn between -Pi and Pi as the angle wraps around
+	turn := math.Remainder(float64(angle-r.angle), 2*math.Pi)
+	if r.rotating {
+		if turn != 0 {
+			r.emit(Event{Kind: Rotate, Time: now, X: centerX, Y: centerY, Rotation: float32(turn)})
+		}
+	} else {
+		r.rotation += float32(turn)
+		if math.Abs(float64(r.rotation)) >= RotateThreshold {
+			r.rotating = true
+			r.emit(Event{Kind: Rotate, Time: now, X: centerX, Y: centerY, Rotation: r.rotation})
+		}
+	}
+
+	r.centerX, r.centerY, r.distance, r.angle = centerX, centerY, distance, angle
+}
+
+func (r *Recognizer) touchUp(event TouchEvent) {
+	i := r.find(event.ID)
+	if i < 0 {
+		return
+	}
+	r.touches = append(r.touches[:i], r.touches[i+1:]...)
+
+	switch len(r.touches) {
+	case 0:
+		if event.Phase == TouchCancel {
+			return
+		}
+		r.release(event)
+	case 1:
+		// The finger left behind carries on panning from where it is
+		r.panning = true
+		r.lastX, r.lastY = r.touches[0].x, r.touches[0].y
+	default:
+		if i < 2 {
+			r.startTwoFingers()
+		}
+	}
+}
+
+// release finishes a gesture as the last finger is lifted
+func (r *Recognizer) release(event TouchEvent) {
+	if r.multiTouch || r.longPressed {
+		return
+	}
+	if r.panning {
+		velocityX, velocityY := r.velocity.Velocity(event.Time)
+		r.emit(Event{Kind: Fling, Time: event.Time, X: event.X, Y: event.Y, VelocityX: velocityX, VelocityY: velocityY})
+		return
+	}
+	if event.Time.Sub(r.downAt) >= LongPressDuration {
+		return
+	}
+
+	// A tap, which may be the second of a double tap
+	if !r.lastTap.IsZero() && event.Time.Sub(r.lastTap) <= DoubleTapInterval &&
+		math.Hypot(float64(event.X-r.lastTapX), float64(event.Y-r.lastTapY)) <= DoubleTapSlop {
+		r.lastTap = time.Time{}
+		r.emit(Event{Kind: DoubleTap, Time: event.Time, X: event.X, Y: event.Y})
+		return
+	}
+	r.lastTap = event.Time
+	r.lastTapX, r.lastTapY = event.X, event.Y
+	r.emit(Event{Kind: Tap, Time: event.Time, X: event.X, Y: event.Y})
+}
@@ -0,0 +1,166 @@
+package gesture
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// record scripts gestures with a simulator, returning what was recognised
+func record(script func(s *Simulator)) []Event {
+	events := []Event{}
+	s := NewSimulator(time.Unix(0, 0), func(event Event) {
+		events = append(events, event)
+	})
+	script(s)
+
+	return events
+}
+
+func kinds(events []Event) []Kind {
+	found := make([]Kind, 0, len(events))
+	for _, event := range events {
+		found = append(found, event.Kind)
+	}
+
+	return found
+}
+
+func expectKinds(t *testing.T, events []Event, expected ...Kind) {
+	t.Helper()
+
+	found := kinds(events)
+	if len(found) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, found)
+		}
+	}
+}
+
+func TestRecognizer_Pan(t *testing.T) {
+	events := record(func(s *Simulator) {
+		s.Drag(100, 100, 200, 150, 10, 100*time.Millisecond)
+	})
+
+	// Panning only starts once the finger leaves the tap slop
+	if events[0].Kind != Press || events[1].Kind != Pan || events[len(events)-1].Kind != Fling {
+		t.Fatalf("unexpected gestures %v", kinds(events))
+	}
+	var dX, dY float32
+	for _, event := range events {
+		dX += event.DX
+		dY += event.DY
+	}
+	if dX != 100 || dY != 50 {
+		t.Errorf("expected to pan (100, 50), got (%f, %f)", dX, dY)
+	}
+
+	fling := events[len(events)-1]
+	if math.Abs(float64(fling.VelocityX)-1000) > 1 || math.Abs(float64(fling.VelocityY)-500) > 1 {
+		t.Errorf("expected fling at (1000, 500), got (%f, %f)", fling.VelocityX, fling.VelocityY)
+	}
+
+	// Small wobbles are not a pan
+	events = record(func(s *Simulator) {
+		s.Down(0, 100, 100)
+		s.Move(0, 104, 103)
+		s.Cancel(0, 104, 103)
+	})
+	expectKinds(t, events, Press)
+}
+
+func TestRecognizer_DoubleTap(t *testing.T) {
+	events := record(func(s *Simulator) {
+		s.Tap(100, 100)
+		s.Wait(100 * time.Millisecond)
+		s.Tap(110, 95)
+	})
+	expectKinds(t, events, Press, Tap, Press, DoubleTap)
+	if events[3].X != 110 || events[3].Y != 95 {
+		t.Errorf("double tap at (%f, %f)", events[3].X, events[3].Y)
+	}
+
+	// Too slow or too far apart
+	events = record(func(s *Simulator) {
+		s.Tap(100, 100)
+		s.Wait(DoubleTapInterval)
+		s.Tap(100, 100)
+		s.Wait(50 * time.Millisecond)
+		s.Tap(200, 100)
+	})
+	expectKinds(t, events, Press, Tap, Press, Tap, Press, Tap)
+}
+
+func TestRecognizer_LongPress(t *testing.T) {
+	events := record(func(s *Simulator) {
+		s.Down(0, 100, 100)
+		s.Wait(LongPressDuration / 2)
+		s.Move(0, 102, 101)
+		s.Wait(LongPressDuration / 2)
+		s.Wait(LongPressDuration)
+		s.Up(0, 102, 101)
+	})
+	// Recognised once, without the lift counting as a tap
+	expectKinds(t, events, Press, LongPress)
+	if events[1].X != 100 || events[1].Y != 100 {
+		t.Errorf("long press at (%f, %f)", events[1].X, events[1].Y)
+	}
+}
+
+func TestRecognizer_Pinch(t *testing.T) {
+	events := record(func(s *Simulator) {
+		s.Down(0, 100, 200)
+		s.Down(1, 300, 200)
+		s.Wait(10 * time.Millisecond)
+		s.Move(0, 50, 200)
+		s.Move(1, 350, 200)
+		s.Wait(10 * time.Millisecond)
+		s.Up(0, 50, 200)
+		s.Up(1, 350, 200)
+	})
+
+	// Spreading the fingers evenly zooms about the point between them,
+	// what little panning there is along the way cancelling out, and
+	// lifting them does not fling
+	expectKinds(t, events, Press, Pan, Pinch, Pan, Pinch)
+	scale := events[2].Scale * events[4].Scale
+	if math.Abs(float64(scale)-1.5) > 1e-5 || events[4].X != 200 || events[4].Y != 200 {
+		t.Errorf("expected to scale 1.5 about (200, 200), got %f about (%f, %f)", scale, events[4].X, events[4].Y)
+	}
+	if dX := events[1].DX + events[3].DX; dX != 0 {
+		t.Errorf("unexpected pan %f", dX)
+	}
+}
+
+func TestRecognizer_Rotate(t *testing.T) {
+	angle := func(degrees float64) (x, y float32) {
+		radians := degrees * math.Pi / 180.0
+		return 200 + float32(100*math.Cos(radians)), 200 + float32(100*math.Sin(radians))
+	}
+
+	events := record(func(s *Simulator) {
+		s.Down(0, 200, 200)
+		x, y := angle(0)
+		s.Down(1, x, y)
+		for _, degrees := range []float64{5, 15, 30} {
+			x, y = angle(degrees)
+			s.Move(1, x, y)
+		}
+	})
+
+	// Turning a little does not rotate, past the threshold it does
+	rotation := float32(0)
+	rotates := 0
+	for _, event := range events {
+		if event.Kind == Rotate {
+			rotation += event.Rotation
+			rotates++
+		}
+	}
+	if rotates != 2 || math.Abs(float64(rotation)-30*math.Pi/180.0) > 1e-4 {
+		t.Errorf("expected two rotations of 30 degrees in all, got %d of %f", rotates, rotation)
+	}
+}
@@ -0,0 +1,66 @@
+package gesture
+
+import (
+	"time"
+)
+
+// Simulator feeds a Recognizer synthetic touches on a clock of its own, so
+// that gestures can be scripted in tests without real input or waiting.
+type Simulator struct {
+	Recognizer *Recognizer
+	Now        time.Time
+}
+
+// NewSimulator starts a recogniser handing its gestures to handler, with the
+// clock at start
+func NewSimulator(start time.Time, handler func(Event)) *Simulator {
+	return &Simulator{
+		Recognizer: NewRecognizer(handler),
+		Now:        start,
+	}
+}
+
+// Wait moves the clock on, updating the recogniser as a frame would
+func (s *Simulator) Wait(d time.Duration) {
+	s.Now = s.Now.Add(d)
+	s.Recognizer.Update(s.Now)
+}
+
+func (s *Simulator) touch(id int, phase Phase, x, y float32) {
+	s.Recognizer.Touch(TouchEvent{ID: id, Phase: phase, X: x, Y: y, Time: s.Now})
+}
+
+func (s *Simulator) Down(id int, x, y float32) {
+	s.touch(id, TouchDown, x, y)
+}
+
+func (s *Simulator) Move(id int, x, y float32) {
+	s.touch(id, TouchMove, x, y)
+}
+
+func (s *Simulator) Up(id int, x, y float32) {
+	s.touch(id, TouchUp, x, y)
+}
+
+func (s *Simulator) Cancel(id int, x, y float32) {
+	s.touch(id, TouchCancel, x, y)
+}
+
+// Tap puts a finger down at x, y and lifts it straight away
+func (s *Simulator) Tap(x, y float32) {
+	s.Down(0, x, y)
+	s.Wait(50 * time.Millisecond)
+	s.Up(0, x, y)
+}
+
+// Drag moves a finger from one point to another in steps over d, lifting
+// it at the end
+func (s *Simulator) Drag(fromX, fromY, toX, toY float32, steps int, d time.Duration) {
+	s.Down(0, fromX, fromY)
+	for i := 1; i <= steps; i++ {
+		s.Wait(d / time.Duration(steps))
+		progress := float32(i) / float32(steps)
+		s.Move(0, fromX+(toX-fromX)*progress, fromY+(toY-fromY)*progress)
+	}
+	s.Up(0, toX, toY)
+}
@@ -0,0 +1,58 @@
+package gesture
+
+import (
+	"time"
+)
+
+// VelocityWindow is how much of the end of a pan its speed is taken from
+// when flinging
+const VelocityWindow = 100 * time.Millisecond
+
+type velocitySample struct {
+	at time.Time
+	dX float32
+	dY float32
+}
+
+// velocityTracker estimates how fast a pan was going at its end
+type velocityTracker struct {
+	start   time.Time
+	samples []velocitySample
+}
+
+// Reset starts tracking a new pan
+func (v *velocityTracker) Reset(now time.Time) {
+	v.start = now
+	v.samples = v.samples[:0]
+}
+
+func (v *velocityTracker) Add(now time.Time, dX, dY float32) {
+	// Only the last VelocityWindow is needed
+	for len(v.samples) > 0 && now.Sub(v.samples[0].at) > VelocityWindow {
+		v.samples = v.samples[1:]
+	}
+	v.samples = append(v.samples, velocitySample{at: now, dX: dX, dY: dY})
+}
+
+// Velocity is the distance panned over the VelocityWindow up to now, in
+// pixels a second. Holding still before letting go means no velocity.
+func (v *velocityTracker) Velocity(now time.Time) (velocityX, velocityY float32) {
+	window := VelocityWindow
+	if since := now.Sub(v.start); since < window {
+		window = since
+	}
+	if window <= 0 {
+		return 0, 0
+	}
+
+	for _, sample := range v.samples {
+		if now.Sub(sample.at) > window {
+			continue
+		}
+		velocityX += sample.dX
+		velocityY += sample.dY
+	}
+	seconds := float32(window.Seconds())
+
+	return velocityX / seconds, velocityY / seconds
+}
@@ -0,0 +1,34 @@
+package gesture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVelocityTracker(t *testing.T) {
+	start := time.Now()
+	tracker := velocityTracker{}
+	tracker.Reset(start)
+
+	// Only the end of the drag counts
+	tracker.Add(start.Add(10*time.Millisecond), 100, 0)
+	for i := 1; i <= 10; i++ {
+		tracker.Add(start.Add(time.Duration(100+i*10)*time.Millisecond), 5, -2)
+	}
+	end := start.Add(200 * time.Millisecond)
+	if vX, vY := tracker.Velocity(end); vX != 500 || vY != -200 {
+		t.Errorf("expected velocity (500, -200), got (%f, %f)", vX, vY)
+	}
+
+	// Holding still before letting go
+	if vX, vY := tracker.Velocity(end.Add(time.Second)); vX != 0 || vY != 0 {
+		t.Errorf("expected no velocity, got (%f, %f)", vX, vY)
+	}
+
+	// A quick flick shorter than the window
+	tracker.Reset(end)
+	tracker.Add(end.Add(20*time.Millisecond), 0, 40)
+	if vX, vY := tracker.Velocity(end.Add(40 * time.Millisecond)); vX != 0 || vY != 1000 {
+		t.Errorf("expected velocity (0, 1000), got (%f, %f)", vX, vY)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"image"
+
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+var _ Renderer = (*GLRenderer)(nil)
+
+// GLRenderer draws with OpenGL 2.1 immediate mode
+type GLRenderer struct {
+	width    float32
+	height   float32
+	rotation Rotation
+}
+
+func NewGLRenderer(width, height uint32) (*GLRenderer, error) {
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+
+	renderer := &GLRenderer{}
+	renderer.Resize(width, height)
+	// Go images have premultiplied alpha
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+
+	return renderer, nil
+}
+
+func (r *GLRenderer) Upload(img image.Image) (Texture, error) {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return 0, err
+	}
+
+	texture := r.newTexture()
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RGBA,
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		0,
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(rgba.Pix))
+
+	return Texture(texture), nil
+}
+
+func (r *GLRenderer) NewTexture(width, height int) (Texture, error) {
+	texture := r.newTexture()
+	gl.TexImage2D(
+		gl.TEXTURE_2D,
+		0,
+		gl.RGBA,
+		int32(width),
+		int32(height),
+		0,
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		nil)
+
+	return Texture(texture), nil
+}
+
+func (r *GLRenderer) UploadRegion(texture Texture, x, y int, img image.Image) error {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return err
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, uint32(texture))
+	gl.TexSubImage2D(
+		gl.TEXTURE_2D,
+		0,
+		int32(x),
+		int32(y),
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		gl.RGBA,
+		gl.UNSIGNED_BYTE,
+		gl.Ptr(rgba.Pix))
+
+	return nil
+}
+
+// newTexture creates and binds a texture, leaving its contents to be set
+func (r *GLRenderer) newTexture() uint32 {
+	var texture uint32
+	gl.Enable(gl.TEXTURE_2D)
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	return texture
+}
+
+func (r *GLRenderer) Draw(texture Texture, dst Rect, src Rect) {
+	// Oh, the fun of the OpenGL coordinate system...
+	clip := r.rotation.corners(dst)
+	for i := range clip {
+		clip[i][0] = clip[i][0]/r.width*2.0 - 1.0
+		clip[i][1] = 1.0 - clip[i][1]/r.height*2.0
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, uint32(texture))
+	gl.Begin(gl.QUADS)
+
+	gl.TexCoord2f(src.X, src.Y)
+	gl.Vertex3f(clip[0][0], clip[0][1], 1)
+	gl.TexCoord2f(src.X+src.W, src.Y)
+	gl.Vertex3f(clip[1][0], clip[1][1], 1)
+	gl.TexCoord2f(src.X+src.W, src.Y+src.H)
+	gl.Vertex3f(clip[2][0], clip[2][1], 1)
+	gl.TexCoord2f(src.X, src.Y+src.H)
+	gl.Vertex3f(clip[3][0], clip[3][1], 1)
+
+	gl.End()
+}
+
+func (r *GLRenderer) SetRotation(rotation Rotation) {
+	r.rotation = rotation
+}
+
+// Flush does nothing as immediate mode draws straight away
+func (r *GLRenderer) Flush() {}
+
+func (r *GLRenderer) Delete(texture Texture) {
+	handle := uint32(texture)
+	gl.DeleteTextures(1, &handle)
+}
+
+func (r *GLRenderer) Clear() {
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.LoadIdentity()
+}
+
+func (r *GLRenderer) Resize(width, height uint32) {
+	r.width = float32(width)
+	r.height = float32(height)
+	gl.Viewport(0, 0, int32(width), int32(height))
+}
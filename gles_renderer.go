@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/go-gl/gl/v3.1/gles2"
+)
+
+var _ Renderer = (*GLESRenderer)(nil)
+
+const (
+	glesVertexShader = `
+#version 100
+uniform vec2 viewSize;
+attribute vec2 position;
+attribute vec2 texCoord;
+varying vec2 fragTexCoord;
+
+void main() {
+	fragTexCoord = texCoord;
+	vec2 clip = position / viewSize * 2.0 - 1.0;
+	gl_Position = vec4(clip.x, -clip.y, 0.0, 1.0);
+}
+` + "\x00"
+
+	glesFragmentShader = `
+#version 100
+precision mediump float;
+uniform sampler2D tex;
+varying vec2 fragTexCoord;
+
+void main() {
+	gl_FragColor = texture2D(tex, fragTexCoord);
+}
+` + "\x00"
+)
+
+// GLESRenderer draws with shaders and a vertex buffer, which works on OpenGL
+// ES 2.0 drivers as found on phones. Quads are queued up and drawn together
+// when the frame is flushed, with one draw call per run of the same texture.
+type GLESRenderer struct {
+	width    float32
+	height   float32
+	program  uint32
+	buffer   uint32
+	viewSize int32
+	position uint32
+	texCoord uint32
+	quads    quadBatch
+}
+
+func NewGLESRenderer(width, height uint32) (*GLESRenderer, error) {
+	if err := gles2.Init(); err != nil {
+		return nil, err
+	}
+
+	program, err := linkProgram(glesVertexShader, glesFragmentShader)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer := &GLESRenderer{
+		program:  program,
+		viewSize: gles2.GetUniformLocation(program, gles2.Str("viewSize\x00")),
+		position: uint32(gles2.GetAttribLocation(program, gles2.Str("position\x00"))),
+		texCoord: uint32(gles2.GetAttribLocation(program, gles2.Str("texCoord\x00"))),
+	}
+	gles2.UseProgram(program)
+	// Go images have premultiplied alpha
+	gles2.Enable(gles2.BLEND)
+	gles2.BlendFunc(gles2.ONE, gles2.ONE_MINUS_SRC_ALPHA)
+	gles2.Uniform1i(gles2.GetUniformLocation(program, gles2.Str("tex\x00")), 0)
+	gles2.GenBuffers(1, &renderer.buffer)
+	renderer.Resize(width, height)
+
+	return renderer, nil
+}
+
+func compileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gles2.CreateShader(shaderType)
+	csource, free := gles2.Strs(source)
+	gles2.ShaderSource(shader, 1, csource, nil)
+	free()
+	gles2.CompileShader(shader)
+
+	var status int32
+	gles2.GetShaderiv(shader, gles2.COMPILE_STATUS, &status)
+	if status == gles2.FALSE {
+		var logLength int32
+		gles2.GetShaderiv(shader, gles2.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gles2.GetShaderInfoLog(shader, logLength, nil, gles2.Str(log))
+		gles2.DeleteShader(shader)
+
+		return 0, fmt.Errorf("failed to compile shader: %s", strings.TrimRight(log, "\x00"))
+	}
+
+	return shader, nil
+}
+
+func linkProgram(vertexSource, fragmentSource string) (uint32, error) {
+	vertexShader, err := compileShader(vertexSource, gles2.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gles2.DeleteShader(vertexShader)
+	fragmentShader, err := compileShader(fragmentSource, gles2.FRAGMENT_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gles2.DeleteShader(fragmentShader)
+
+	program := gles2.CreateProgram()
+	gles2.AttachShader(program, vertexShader)
+	gles2.AttachShader(program, fragmentShader)
+	gles2.LinkProgram(program)
+
+	var status int32
+	gles2.GetProgramiv(program, gles2.LINK_STATUS, &status)
+	if status == gles2.FALSE {
+		var logLength int32
+		gles2.GetProgramiv(program, gles2.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gles2.GetProgramInfoLog(program, logLength, nil, gles2.Str(log))
+		gles2.DeleteProgram(program)
+
+		return 0, fmt.Errorf("failed to link program: %s", strings.TrimRight(log, "\x00"))
+	}
+
+	return program, nil
+}
+
+func (r *GLESRenderer) Upload(img image.Image) (Texture, error) {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return 0, err
+	}
+
+	texture := r.newTexture()
+	gles2.TexImage2D(
+		gles2.TEXTURE_2D,
+		0,
+		gles2.RGBA,
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		0,
+		gles2.RGBA,
+		gles2.UNSIGNED_BYTE,
+		gles2.Ptr(rgba.Pix))
+
+	return Texture(texture), nil
+}
+
+func (r *GLESRenderer) NewTexture(width, height int) (Texture, error) {
+	texture := r.newTexture()
+	gles2.TexImage2D(
+		gles2.TEXTURE_2D,
+		0,
+		gles2.RGBA,
+		int32(width),
+		int32(height),
+		0,
+		gles2.RGBA,
+		gles2.UNSIGNED_BYTE,
+		nil)
+
+	return Texture(texture), nil
+}
+
+func (r *GLESRenderer) UploadRegion(texture Texture, x, y int, img image.Image) error {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return err
+	}
+
+	gles2.BindTexture(gles2.TEXTURE_2D, uint32(texture))
+	gles2.TexSubImage2D(
+		gles2.TEXTURE_2D,
+		0,
+		int32(x),
+		int32(y),
+		int32(rgba.Rect.Size().X),
+		int32(rgba.Rect.Size().Y),
+		gles2.RGBA,
+		gles2.UNSIGNED_BYTE,
+		gles2.Ptr(rgba.Pix))
+
+	return nil
+}
+
+// newTexture creates and binds a texture, leaving its contents to be set
+func (r *GLESRenderer) newTexture() uint32 {
+	var texture uint32
+	gles2.GenTextures(1, &texture)
+	gles2.BindTexture(gles2.TEXTURE_2D, texture)
+	gles2.TexParameteri(gles2.TEXTURE_2D, gles2.TEXTURE_MIN_FILTER, gles2.LINEAR)
+	gles2.TexParameteri(gles2.TEXTURE_2D, gles2.TEXTURE_MAG_FILTER, gles2.LINEAR)
+	gles2.TexParameteri(gles2.TEXTURE_2D, gles2.TEXTURE_WRAP_S, gles2.CLAMP_TO_EDGE)
+	gles2.TexParameteri(gles2.TEXTURE_2D, gles2.TEXTURE_WRAP_T, gles2.CLAMP_TO_EDGE)
+
+	return texture
+}
+
+// Draw queues the quad to be drawn when the frame is flushed
+func (r *GLESRenderer) Draw(texture Texture, dst Rect, src Rect) {
+	r.quads.Add(texture, dst, src)
+}
+
+func (r *GLESRenderer) SetRotation(rotation Rotation) {
+	r.quads.rotation = rotation
+}
+
+// Flush uploads all quads of the frame in one go then draws them
+func (r *GLESRenderer) Flush() {
+	if len(r.quads.runs) == 0 {
+		return
+	}
+
+	gles2.UseProgram(r.program)
+	gles2.Uniform2f(r.viewSize, r.width, r.height)
+
+	gles2.BindBuffer(gles2.ARRAY_BUFFER, r.buffer)
+	gles2.BufferData(gles2.ARRAY_BUFFER, len(r.quads.vertices)*4, gles2.Ptr(r.quads.vertices), gles2.STREAM_DRAW)
+
+	stride := int32(quadVertexFloats * 4)
+	gles2.EnableVertexAttribArray(r.position)
+	gles2.VertexAttribPointer(r.position, 2, gles2.FLOAT, false, stride, gles2.PtrOffset(0))
+	gles2.EnableVertexAttribArray(r.texCoord)
+	gles2.VertexAttribPointer(r.texCoord, 2, gles2.FLOAT, false, stride, gles2.PtrOffset(2*4))
+
+	gles2.ActiveTexture(gles2.TEXTURE0)
+	for _, run := range r.quads.runs {
+		gles2.BindTexture(gles2.TEXTURE_2D, uint32(run.texture))
+		gles2.DrawArrays(gles2.TRIANGLES, run.first, run.count)
+	}
+
+	r.quads.Reset()
+}
+
+func (r *GLESRenderer) Delete(texture Texture) {
+	handle := uint32(texture)
+	gles2.DeleteTextures(1, &handle)
+}
+
+func (r *GLESRenderer) Clear() {
+	r.quads.Reset()
+	gles2.Clear(gles2.COLOR_BUFFER_BIT)
+}
+
+func (r *GLESRenderer) Resize(width, height uint32) {
+	r.width = float32(width)
+	r.height = float32(height)
+	gles2.Viewport(0, 0, int32(width), int32(height))
+}
@@ -10,6 +10,7 @@ require (
 	github.com/go-gl/gl v0.0.0-20211210172815-726fda9656d6
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20220320163800-277f93cfa958
 	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/mattn/go-sqlite3 v1.14.15
 )
 
 replace cartog/tile => ./tile
@@ -1,16 +1,35 @@
 package main
 
 import (
+	"cartog/projection"
 	"cartog/tile"
 	"context"
 	"errors"
 	"log"
+	"math"
 	"sync"
+	"time"
 )
 
 const (
 	MAX_ZOOM = 16
 	MIN_ZOOM = 2
+
+	// MIN_FLING_SPEED is the speed in pixels a second below which a fling
+	// stops, and MAX_FLING_SPEED the fastest one can start
+	MIN_FLING_SPEED = 10.0
+	MAX_FLING_SPEED = 8000.0
+
+	// ZOOM_EPSILON absorbs rounding errors in zoom levels which should be
+	// whole, so that the tiles of the level below are not drawn instead
+	ZOOM_EPSILON = 1e-3
+
+	// FETCH_RETRY_DELAY is how long a tile which failed to fetch is left
+	// before it is tried again, doubling each time it fails up to
+	// FETCH_RETRY_MAX_DELAY. Tiles the source does not have wait the longest
+	// straight away.
+	FETCH_RETRY_DELAY     = 5 * time.Second
+	FETCH_RETRY_MAX_DELAY = 5 * time.Minute
 )
 
 type Coord struct {
@@ -19,63 +38,114 @@ type Coord struct {
 	Z float32
 }
 
+// tileFetch is a tile being fetched, which may be cancelled
+type tileFetch struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// fetchFailure is when a tile which failed to fetch may be tried again
+type fetchFailure struct {
+	retryAt time.Time
+	delay   time.Duration
+}
+
+// zoomAnimation eases the zoom from one level to another
+type zoomAnimation struct {
+	from     float32
+	to       float32
+	anchorX  float32
+	anchorY  float32
+	start    time.Time
+	duration time.Duration
+}
+
+// flingAnimation glides the view on after a drag, in pixels a second
+type flingAnimation struct {
+	velocityX float32
+	velocityY float32
+	last      time.Time
+}
+
+// TileGrid is the part of the world in view and the tiles it needs. Tiles
+// are fetched on other goroutines, but the view is only moved, zoomed,
+// turned and animated from one, the main loop.
 type TileGrid struct {
+	source         tile.TileSource
 	location       Coord
 	tileWidth      float32
 	tileHeight     float32
-	halfTileWidth  float32
-	halfTileHeight float32
-	cache          sync.Map
+	cache          *tileCache
 	loading        sync.Map
 	viewWidth      float32
 	viewHeight     float32
 	ViewTileWidth  uint32
 	ViewTileHeight uint32
-	TilesToLoad    chan tile.TileCoord
-	TilesToExpire  chan tile.TileCoord
-	TilesInFlight  chan func()
+	queue          *fetchQueue
+	// inFlightMu guards both the tiles being fetched and those which failed
+	inFlightMu    sync.Mutex
+	inFlight      map[tile.TileCoord]*tileFetch
+	failed        map[tile.TileCoord]*fetchFailure
+	TilesToExpire chan tile.PngTile
+	// bearing is the compass direction the top of the view faces, in
+	// degrees clockwise from north. The view turns about its middle.
+	bearing float32
+	// ZoomDuration is how long ZoomBy takes to get to the new zoom level
+	ZoomDuration time.Duration
+	// FlingFriction is how quickly a fling slows down, the fraction of its
+	// speed lost each second being 1 - e^-FlingFriction. No friction turns
+	// flinging off.
+	FlingFriction  float32
+	zoomAnimation  *zoomAnimation
+	flingAnimation *flingAnimation
 }
 
-func (c *Coord) Add(a Coord) {
-	c.X += a.X
-	c.Y += a.Y
-	if a.Z < 0 {
-		c.X /= 2.0
-		c.Y /= 2.0
-		c.Z += a.Z
-	} else if a.Z > 0 {
-		c.X *= 2.0
-		c.Y *= 2.0
-		c.Z += a.Z
+// LatLonToCoord finds the world pixel coordinates of a WGS84 position at zoom
+func LatLonToCoord(lat, lon float64, zoom float32, tileSize uint32) Coord {
+	x, y := projection.LatLonToPixel(lat, lon, float64(zoom), float64(tileSize))
+
+	return Coord{
+		X: float32(x),
+		Y: float32(y),
+		Z: zoom,
 	}
-	if c.Z > MAX_ZOOM {
-		c.Z = MAX_ZOOM
-	} else if c.Z < MIN_ZOOM {
-		c.Z = MIN_ZOOM
+}
+
+func NewTileGrid(source tile.TileSource, origin Coord, viewWidth, viewHeight uint32) (*TileGrid, error) {
+	grid, err := newTileGrid(source, origin, viewWidth, viewHeight)
+	if err != nil {
+		return nil, err
 	}
+	grid.SetLocation(origin)
 
+	return grid, nil
 }
 
-func NewTileGrid(origin Coord, tileWidth, tileHeight, viewWidth, viewHeight uint32) (*TileGrid, error) {
+// newTileGrid sets up the grid without queueing any tiles to load
+func newTileGrid(source tile.TileSource, origin Coord, viewWidth, viewHeight uint32) (*TileGrid, error) {
+	if source == nil {
+		return nil, errors.New("tile source must be provided")
+	}
+	tileWidth := source.Info().TileSize
+	tileHeight := source.Info().TileSize
 	if tileWidth == 0 || tileHeight == 0 {
 		return nil, errors.New("tile width and height must be positive")
 	}
 
 	grid := &TileGrid{
+		source:        source,
 		location:      origin,
-		cache:         sync.Map{},
+		cache:         newTileCache(DEFAULT_CACHE_TILES, DEFAULT_CACHE_TEXTURE_BYTES),
 		loading:       sync.Map{},
-		TilesToLoad:   make(chan tile.TileCoord),
-		TilesToExpire: make(chan tile.TileCoord),
-		TilesInFlight: make(chan func()),
+		queue:         newFetchQueue(),
+		inFlight:      map[tile.TileCoord]*tileFetch{},
+		failed:        map[tile.TileCoord]*fetchFailure{},
+		TilesToExpire: make(chan tile.PngTile, DEFAULT_CACHE_TILES),
 
-		tileWidth:      float32(tileWidth),
-		tileHeight:     float32(tileHeight),
-		halfTileWidth:  float32(tileWidth) / 2.0,
-		halfTileHeight: float32(tileHeight) / 2.0,
+		tileWidth:  float32(tileWidth),
+		tileHeight: float32(tileHeight),
 	}
 	grid.Resize(viewWidth, viewHeight)
-	grid.SetLocation(origin)
 
 	return grid, nil
 }
@@ -87,51 +157,159 @@ func (t *TileGrid) Resize(width, height uint32) {
 	t.ViewTileHeight = height / uint32(t.tileHeight)
 }
 
-func (t *TileGrid) forEachVisibleTile(f func(tile.TileCoord)) {
-	x1 := t.location.X - t.tileWidth
-	x2 := t.location.X + t.halfTileWidth + t.viewWidth
-	y1 := t.location.Y - t.tileHeight
-	y2 := t.location.Y + t.halfTileHeight + t.viewHeight
+// tileZoom is the zoom level of the tiles drawn, which are scaled up by
+// tileScale in between whole zoom levels
+func (t *TileGrid) tileZoom() uint32 {
+	z := math.Floor(float64(t.location.Z) + ZOOM_EPSILON)
+	if z < 0 {
+		return 0
+	}
 
-	for x := x1; x < x2; x += t.tileWidth {
-		for y := y1; y < y2; y += t.tileHeight {
-			tX := x / t.tileWidth
-			tY := y / t.tileHeight
+	return uint32(z)
+}
 
-			if tX < 0 {
-				tX = 0.0
-			}
-			if tY < 0 {
-				tY = 0
-			}
+func (t *TileGrid) tileScale() float32 {
+	return float32(math.Exp2(float64(t.location.Z) - float64(t.tileZoom())))
+}
 
-			tileCoord := tile.TileCoord{
-				X: uint32(tX),
-				Y: uint32(tY),
-				Z: uint32(t.location.Z),
-			}
+// TileSize is how large tiles are drawn in the view at the current zoom
+func (t *TileGrid) TileSize() (width, height float32) {
+	scale := t.tileScale()
+
+	return t.tileWidth * scale, t.tileHeight * scale
+}
+
+// Bearing is the compass direction the top of the view faces, in degrees
+// clockwise from north
+func (t *TileGrid) Bearing() float32 {
+	return t.bearing
+}
+
+// SetBearing turns the view about its middle to face bearing
+func (t *TileGrid) SetBearing(bearing float32) {
+	t.RotateAt(bearing-t.bearing, t.viewWidth/2.0, t.viewHeight/2.0)
+}
+
+// RotateAt turns the view by delta degrees clockwise, keeping the point x, y
+// of the view in the same place
+func (t *TileGrid) RotateAt(delta float32, x, y float32) {
+	anchorX, anchorY := t.screenToWorld(x, y)
+
+	bearing := math.Mod(float64(t.bearing+delta), 360)
+	if bearing < 0 {
+		bearing += 360
+	}
+	t.bearing = float32(bearing)
+
+	offsetX, offsetY := t.bearingRotation().Apply(x-t.viewWidth/2.0, y-t.viewHeight/2.0)
+	location := t.location
+	location.X = anchorX - offsetX - t.viewWidth/2.0
+	location.Y = anchorY - offsetY - t.viewHeight/2.0
+	t.SetLocation(location)
+}
+
+// bearingRotation turns distances in the view into distances in the world
+func (t *TileGrid) bearingRotation() Rotation {
+	return Rotation{Angle: t.bearing * math.Pi / 180.0}
+}
+
+// ViewRotation turns the tiles, placed by ScreenPosition, to the bearing
+func (t *TileGrid) ViewRotation() Rotation {
+	return Rotation{
+		Angle: -t.bearing * math.Pi / 180.0,
+		X:     t.viewWidth / 2.0,
+		Y:     t.viewHeight / 2.0,
+	}
+}
+
+// screenToWorld finds the world pixel under a point of the view
+func (t *TileGrid) screenToWorld(x, y float32) (worldX, worldY float32) {
+	offsetX, offsetY := t.bearingRotation().Apply(x-t.viewWidth/2.0, y-t.viewHeight/2.0)
+
+	return t.location.X + t.viewWidth/2.0 + offsetX, t.location.Y + t.viewHeight/2.0 + offsetY
+}
+
+// visibleBounds is the area of the world in view, in world pixels, which is
+// larger than the view when it is turned
+func (t *TileGrid) visibleBounds() (minX, minY, maxX, maxY float32) {
+	minX, minY = float32(math.Inf(1)), float32(math.Inf(1))
+	maxX, maxY = float32(math.Inf(-1)), float32(math.Inf(-1))
+	for _, corner := range [][2]float32{{0, 0}, {t.viewWidth, 0}, {0, t.viewHeight}, {t.viewWidth, t.viewHeight}} {
+		x, y := t.screenToWorld(corner[0], corner[1])
+		minX = float32(math.Min(float64(minX), float64(x)))
+		minY = float32(math.Min(float64(minY), float64(y)))
+		maxX = float32(math.Max(float64(maxX), float64(x)))
+		maxY = float32(math.Max(float64(maxY), float64(y)))
+	}
+
+	return minX, minY, maxX, maxY
+}
+
+// worldSize is the width and height of the world in the view at the current
+// zoom, in pixels
+func (t *TileGrid) worldSize() (width, height float32) {
+	scale := float32(math.Exp2(float64(t.location.Z)))
+
+	return t.tileWidth * scale, t.tileHeight * scale
+}
+
+// forEachVisibleTile calls f with each tile in view. The world repeats to
+// the east and west, wrap being which copy of it the tile is in, with 0 the
+// world itself and -1 the one to the west of it.
+func (t *TileGrid) forEachVisibleTile(f func(coord tile.TileCoord, wrap int)) {
+	tileWidth, tileHeight := t.TileSize()
+	minX, minY, maxX, maxY := t.visibleBounds()
+	// The tiles just before the view are loaded ahead of being scrolled to
+	x1 := int(math.Floor(float64(minX/tileWidth))) - 1
+	x2 := int(math.Floor(float64(maxX / tileWidth)))
+	y1 := int(math.Floor(float64(minY/tileHeight))) - 1
+	y2 := int(math.Floor(float64(maxY / tileHeight)))
+	z := t.tileZoom()
+
+	// There is nothing past the poles
+	tiles := 1 << z
+	if y1 < 0 {
+		y1 = 0
+	}
+	if y2 > tiles-1 {
+		y2 = tiles - 1
+	}
 
-			f(tileCoord)
+	for x := x1; x <= x2; x++ {
+		wrap := int(math.Floor(float64(x) / float64(tiles)))
+		for y := y1; y <= y2; y++ {
+			f(tile.TileCoord{
+				X: uint32(x - wrap*tiles),
+				Y: uint32(y),
+				Z: z,
+			}, wrap)
 		}
 	}
 }
 
+// ScreenPosition is where the top left corner of the tile is in the view, in
+// pixels from the top left of the view, before it is turned by ViewRotation
+func (t *TileGrid) ScreenPosition(coord tile.TileCoord) (x, y float32) {
+	tileWidth, tileHeight := t.TileSize()
+	x = float32(coord.X)*tileWidth - t.location.X
+	y = float32(coord.Y)*tileHeight - t.location.Y
+
+	return x, y
+}
+
+// CancelLoadingTiles drops every queued tile and cancels those being fetched
 func (t *TileGrid) CancelLoadingTiles() {
-	// Drain all loading tiles
-	for {
-		select {
-		case l := <-t.TilesToLoad:
-			log.Printf("De-queued loading tile: %v", l)
-			t.loading.Delete(l)
-			t.cache.Delete(l)
-		case cancel := <-t.TilesInFlight:
-			log.Printf("Canceling fetch context")
-			cancel()
-		default:
-			goto drained
-		}
+	for _, l := range t.queue.Clear() {
+		log.Printf("De-queued loading tile: %v", l)
 	}
-drained:
+
+	t.inFlightMu.Lock()
+	for coord, fetch := range t.inFlight {
+		log.Printf("Canceling fetch of %v", coord)
+		fetch.cancel()
+		delete(t.inFlight, coord)
+	}
+	t.inFlightMu.Unlock()
 
 	t.loading.Range(func(key interface{}, _ interface{}) bool {
 		t.loading.Delete(key)
@@ -139,81 +317,534 @@ drained:
 	})
 }
 
+// cancelFetch cancels the tile if it is being fetched, so that it is loaded
+// again if it is needed later
+func (t *TileGrid) cancelFetch(coord tile.TileCoord) bool {
+	t.inFlightMu.Lock()
+	defer t.inFlightMu.Unlock()
+
+	fetch, exists := t.inFlight[coord]
+	if exists {
+		log.Printf("Canceling fetch of %v", coord)
+		fetch.cancel()
+		delete(t.inFlight, coord)
+		t.loading.Delete(coord)
+	}
+
+	return exists
+}
+
+// InFlight lists the tiles currently being fetched
+func (t *TileGrid) InFlight() []tile.TileCoord {
+	t.inFlightMu.Lock()
+	defer t.inFlightMu.Unlock()
+
+	coords := make([]tile.TileCoord, 0, len(t.inFlight))
+	for coord := range t.inFlight {
+		coords = append(coords, coord)
+	}
+
+	return coords
+}
+
+// Move pans the view by delta pixels and zooms it straight away by delta
+// levels, which may be fractional, about the middle of the view. Any zoom
+// animation is stopped.
 func (t *TileGrid) Move(delta Coord) {
-	t.location.Add(delta)
+	t.MoveAt(delta, t.viewWidth/2.0, t.viewHeight/2.0)
+}
 
-	// Cancel any inflight requests before loading a new set of tiles
+// MoveAt is Move zooming about the point x, y of the view instead, so that
+// what is under the cursor stays there
+func (t *TileGrid) MoveAt(delta Coord, x, y float32) {
+	// Panning is along the turned view
+	dX, dY := t.bearingRotation().Apply(delta.X, delta.Y)
+	location := t.location
+	location.X += dX
+	location.Y += dY
+
+	// Grabbing the map again stops it gliding
+	if delta.X != 0 || delta.Y != 0 {
+		t.flingAnimation = nil
+	}
 	if delta.Z != 0 {
-		t.CancelLoadingTiles()
-
-		// De/Inc-rement map further to center on center screen
-		if delta.Z < 0 {
-			t.location.Add(Coord{
-				X: -float32(t.ViewTileWidth) / 4.0 * t.tileWidth,
-				Y: -float32(t.ViewTileHeight) / 4.0 * t.tileHeight,
-			})
-		} else {
-			t.location.Add(Coord{
-				X: float32(t.ViewTileWidth) / 2.0 * t.tileWidth,
-				Y: float32(t.ViewTileHeight) / 2.0 * t.tileHeight,
-			})
-		}
+		t.zoomAnimation = nil
+
+		location = t.zoomAround(location, location.Z+delta.Z, x, y)
+	}
+
+	t.SetLocation(location)
+}
+
+// zoomAround changes the zoom of location, keeping the point x, y of the view
+// in the same place
+func (t *TileGrid) zoomAround(location Coord, zoom float32, x, y float32) Coord {
+	zoom = t.clampZoom(zoom)
+	scale := float32(math.Exp2(float64(zoom - location.Z)))
+
+	// Where the point is from the middle of the view, in world pixels
+	offsetX, offsetY := t.bearingRotation().Apply(x-t.viewWidth/2.0, y-t.viewHeight/2.0)
+	centerX := location.X + t.viewWidth/2.0
+	centerY := location.Y + t.viewHeight/2.0
+
+	return Coord{
+		X: (centerX+offsetX)*scale - offsetX - t.viewWidth/2.0,
+		Y: (centerY+offsetY)*scale - offsetY - t.viewHeight/2.0,
+		Z: zoom,
+	}
+}
+
+// clampZoom limits zoom to what both the grid and the tile source support
+func (t *TileGrid) clampZoom(zoom float32) float32 {
+	info := t.source.Info()
+	min := float32(math.Max(MIN_ZOOM, float64(info.MinZoom)))
+	max := float32(math.Min(MAX_ZOOM, float64(info.MaxZoom)))
+	if zoom < min {
+		return min
+	} else if zoom > max {
+		return max
+	}
+
+	return zoom
+}
+
+// ZoomBy zooms by delta levels about the middle of the view, easing into the
+// new level over ZoomDuration as Animate is called each frame. Zooming again
+// before getting there heads on from where the last zoom was going.
+func (t *TileGrid) ZoomBy(delta float32) {
+	t.ZoomByAt(delta, t.viewWidth/2.0, t.viewHeight/2.0)
+}
+
+// ZoomByAt is ZoomBy keeping the point x, y of the view in place instead
+func (t *TileGrid) ZoomByAt(delta float32, x, y float32) {
+	to := t.location.Z + delta
+	if t.zoomAnimation != nil {
+		to = t.zoomAnimation.to + delta
+	}
+	t.zoomAnimation = &zoomAnimation{
+		from:     t.location.Z,
+		to:       t.clampZoom(to),
+		anchorX:  x,
+		anchorY:  y,
+		duration: t.ZoomDuration,
+	}
+
+	if t.ZoomDuration <= 0 {
+		t.animateZoom(time.Now())
+	}
+}
+
+// Fling sets the view gliding at the velocity in pixels a second, slowing
+// down with FlingFriction as Animate is called each frame. Flinging too
+// slowly stops the view instead.
+func (t *TileGrid) Fling(velocityX, velocityY float32) {
+	speed := math.Hypot(float64(velocityX), float64(velocityY))
+	if t.FlingFriction <= 0 || speed < MIN_FLING_SPEED {
+		t.flingAnimation = nil
+		return
+	}
+	if speed > MAX_FLING_SPEED {
+		velocityX *= float32(MAX_FLING_SPEED / speed)
+		velocityY *= float32(MAX_FLING_SPEED / speed)
+	}
+
+	t.flingAnimation = &flingAnimation{
+		velocityX: velocityX,
+		velocityY: velocityY,
+	}
+}
+
+// Animate moves any zoom or fling on to now, returning whether either is
+// still going. It is called every frame, so that animations follow the
+// frame time rather than waiting on input.
+func (t *TileGrid) Animate(now time.Time) bool {
+	zooming := t.animateZoom(now)
+	flinging := t.animateFling(now)
+
+	return zooming || flinging
+}
+
+func (t *TileGrid) animateFling(now time.Time) bool {
+	fling := t.flingAnimation
+	if fling == nil {
+		return false
+	}
+	// Animations start on the next frame, keeping them to frame time
+	if fling.last.IsZero() {
+		fling.last = now
+	}
+	elapsed := now.Sub(fling.last).Seconds()
+	if elapsed <= 0 {
+		return true
+	}
+
+	// The velocity decays exponentially, the distance covered being its
+	// integral over the frame
+	decay := math.Exp(-float64(t.FlingFriction) * elapsed)
+	travelled := float32((1.0 - decay) / float64(t.FlingFriction))
+	dX := fling.velocityX * travelled
+	dY := fling.velocityY * travelled
+	fling.velocityX *= float32(decay)
+	fling.velocityY *= float32(decay)
+	fling.last = now
+
+	gliding := math.Hypot(float64(fling.velocityX), float64(fling.velocityY)) >= MIN_FLING_SPEED
+	if !gliding {
+		t.flingAnimation = nil
+	}
+
+	// The velocity is across the view, which is turned to the bearing
+	dX, dY = t.bearingRotation().Apply(dX, dY)
+	location := t.location
+	location.X += dX
+	location.Y += dY
+	t.SetLocation(location)
+
+	return gliding
+}
+
+func (t *TileGrid) animateZoom(now time.Time) bool {
+	animation := t.zoomAnimation
+	if animation == nil {
+		return false
+	}
+
+	if animation.start.IsZero() {
+		animation.start = now
+	}
+	progress := 1.0
+	if animation.duration > 0 {
+		progress = math.Min(float64(now.Sub(animation.start))/float64(animation.duration), 1.0)
+	}
+	if progress >= 1.0 {
+		t.zoomAnimation = nil
+	}
+
+	zoom := animation.to
+	if progress < 1.0 {
+		// Ease out, slowing down as the zoom level is reached
+		eased := float32(1.0 - math.Pow(1.0-math.Max(progress, 0), 3))
+		zoom = animation.from + (animation.to-animation.from)*eased
 	}
+	t.SetLocation(t.zoomAround(t.location, zoom, animation.anchorX, animation.anchorY))
+
+	return progress < 1.0
+}
 
-	t.SetLocation(t.location)
+// IsLoading reports whether the tile has been queued to load and has not
+// been set or cancelled since
+func (t *TileGrid) IsLoading(coord tile.TileCoord) bool {
+	_, loading := t.loading.Load(coord)
+	return loading
+}
+
+// LoadFailed gives up on a fetched tile which could not be loaded, so that
+// it is fetched again after a while if it is still needed
+func (t *TileGrid) LoadFailed(coord tile.TileCoord, err error) {
+	t.inFlightMu.Lock()
+	defer t.inFlightMu.Unlock()
+
+	t.loading.Delete(coord)
+	t.fetchFailed(coord, err, time.Now())
 }
 
 func (t *TileGrid) SetTile(coord tile.TileCoord, tile tile.PngTile) {
 	t.loading.Delete(coord)
 	t.cache.Store(coord, tile)
+	t.expireTiles()
+}
+
+// SetCacheLimits bounds the number of loaded tiles and their texture memory,
+// a limit of zero disables that bound.
+func (t *TileGrid) SetCacheLimits(maxTiles int, maxTextureBytes int64) {
+	t.cache.SetLimits(maxTiles, maxTextureBytes)
+	t.expireTiles()
+}
+
+// expireTiles evicts off-screen tiles from the cache once it is over its
+// limits, queueing them on TilesToExpire so that their textures can be
+// deleted on the GL thread.
+func (t *TileGrid) expireTiles() {
+	visible := map[tile.TileCoord]bool{}
+	t.forEachVisibleTile(func(tileCoord tile.TileCoord, _ int) {
+		visible[tileCoord] = true
+	})
+
+	evicted := t.cache.Evict(func(tileCoord tile.TileCoord) bool {
+		return visible[tileCoord]
+	})
+	for _, pngTile := range evicted {
+		select {
+		case t.TilesToExpire <- pngTile:
+		default:
+			// Never block the GL thread, which is what drains this channel
+			go func(pngTile tile.PngTile) {
+				t.TilesToExpire <- pngTile
+			}(pngTile)
+		}
+	}
+}
+
+func (t *TileGrid) CacheStats() CacheStats {
+	return t.cache.Stats()
 }
 
 func (t *TileGrid) SetLocation(location Coord) {
-	t.location = location
+	t.location = t.boundLocation(location)
+
+	visible := map[tile.TileCoord]bool{}
+	t.forEachVisibleTile(func(tileCoord tile.TileCoord, _ int) {
+		visible[tileCoord] = true
+	})
+
+	// Tiles which left the view are no longer needed, whether or not they
+	// have started being fetched
+	dropped := t.queue.Retain(func(tileCoord tile.TileCoord) (float64, bool) {
+		return t.fetchPriority(tileCoord), visible[tileCoord]
+	})
+	for _, tileCoord := range dropped {
+		t.loading.Delete(tileCoord)
+	}
+	for _, tileCoord := range t.InFlight() {
+		if !visible[tileCoord] {
+			t.cancelFetch(tileCoord)
+		}
+	}
 
 	// ensure all tiles in screen space are loaded / visible
-	t.forEachVisibleTile(func(tileCoord tile.TileCoord) {
+	now := time.Now()
+	for tileCoord := range visible {
+		if !tileInWorld(tileCoord) {
+			continue
+		}
+		if _, exists := t.loading.Load(tileCoord); exists {
+			continue
+		}
+		if t.cache.Contains(tileCoord) {
+			continue
+		}
+		if !t.canFetch(tileCoord, now) {
+			continue
+		}
+		log.Printf("Adding tile to load %v", tileCoord)
+		t.loading.Store(tileCoord, true)
+		t.queue.Push(tileCoord, t.fetchPriority(tileCoord))
+	}
+}
+
+// boundLocation keeps the view from leaving the map past the poles, and
+// wraps it around the world east to west so that panning never runs out of
+// map or float precision
+func (t *TileGrid) boundLocation(location Coord) Coord {
+	scale := float32(math.Exp2(float64(location.Z)))
+	worldWidth, worldHeight := t.tileWidth*scale, t.tileHeight*scale
+
+	centerX := float64(location.X + t.viewWidth/2.0)
+	centerX = math.Mod(centerX, float64(worldWidth))
+	if centerX < 0 {
+		centerX += float64(worldWidth)
+	}
+	location.X = float32(centerX) - t.viewWidth/2.0
+
+	// How far the turned view reaches above and below its middle
+	sin, cos := math.Sincos(float64(t.bearing) * math.Pi / 180.0)
+	reach := float32(math.Abs(sin)*float64(t.viewWidth)+math.Abs(cos)*float64(t.viewHeight)) / 2.0
+
+	centerY := location.Y + t.viewHeight/2.0
+	if 2*reach >= worldHeight {
+		// The whole height of the world fits, so keep it in the middle
+		centerY = worldHeight / 2.0
+	} else if centerY < reach {
+		centerY = reach
+	} else if centerY > worldHeight-reach {
+		centerY = worldHeight - reach
+	}
+	location.Y = centerY - t.viewHeight/2.0
+
+	return location
+}
+
+// tileInWorld reports whether the tile exists at its zoom level
+func tileInWorld(coord tile.TileCoord) bool {
+	tiles := uint32(1) << coord.Z
+	return coord.X < tiles && coord.Y < tiles
+}
+
+// fetchPriority orders tiles to fetch, those at the current zoom level
+// nearest to the middle of the view coming first
+func (t *TileGrid) fetchPriority(coord tile.TileCoord) float64 {
+	tileWidth, tileHeight := t.TileSize()
+	centerX := float64(t.location.X+t.viewWidth/2.0) / float64(tileWidth)
+	centerY := float64(t.location.Y+t.viewHeight/2.0) / float64(tileHeight)
+	// The nearest copy of the tile counts, across the antimeridian or not
+	tiles := math.Exp2(float64(coord.Z))
+	dX := math.Remainder(float64(coord.X)+0.5-centerX, tiles)
+	distance := math.Hypot(dX, float64(coord.Y)+0.5-centerY)
+
+	// Each zoom level away counts as the width of the world, putting tiles
+	// at other zoom levels behind all of those at the current one
+	zoomDistance := math.Abs(float64(coord.Z) - float64(t.tileZoom()))
+
+	return zoomDistance*math.Exp2(float64(coord.Z)) + distance
+}
+
+// StartFetching starts workers fetching queued tiles, handing each tile to
+// loaded once it has been fetched
+func (t *TileGrid) StartFetching(workers int, loaded func(*tile.PngTile)) {
+	if workers <= 0 {
+		workers = DEFAULT_FETCH_WORKERS
+	}
+
+	for i := 0; i < workers; i++ {
 		go func() {
-			_, exists := t.loading.Load(tileCoord)
-			if exists {
-				return
-			}
-			_, exists = t.cache.Load(tileCoord)
-			if exists {
-				return
+			for {
+				var fetch *tileFetch
+				coord, ok := t.queue.PopWith(func(coord tile.TileCoord) {
+					fetch = t.startFetch(coord)
+				})
+				if !ok {
+					return
+				}
+
+				pngTile, err := t.fetchTile(coord, fetch)
+				if err != nil {
+					log.Printf("fetch error: %s", err)
+					continue
+				}
+				// When a tile is canceled
+				if pngTile == nil {
+					continue
+				}
+
+				loaded(pngTile)
 			}
-			log.Printf("Adding tile to load %v", tileCoord)
-			t.loading.Store(tileCoord, true)
-			t.TilesToLoad <- tileCoord
 		}()
-	})
+	}
+}
+
+// CenterOn moves the view so the WGS84 position is in the middle of it
+func (t *TileGrid) CenterOn(lat, lon float64, zoom float32) {
+	location := LatLonToCoord(lat, lon, t.clampZoom(zoom), uint32(t.tileWidth))
+	location.X -= t.viewWidth / 2.0
+	location.Y -= t.viewHeight / 2.0
+
+	t.SetLocation(location)
+}
+
+// ScreenToLatLon finds the WGS84 position under a point of the view, in
+// pixels from its top left corner
+func (t *TileGrid) ScreenToLatLon(x, y float32) (lat, lon float64) {
+	worldX, worldY := t.screenToWorld(x, y)
+	worldWidth, _ := t.worldSize()
+	wrappedX := math.Mod(float64(worldX), float64(worldWidth))
+	if wrappedX < 0 {
+		wrappedX += float64(worldWidth)
+	}
+
+	return projection.PixelToLatLon(
+		wrappedX,
+		float64(worldY),
+		float64(t.location.Z),
+		float64(t.tileWidth))
+}
+
+// Center is the WGS84 position in the middle of the view
+func (t *TileGrid) Center() (lat, lon float64) {
+	return t.ScreenToLatLon(t.viewWidth/2.0, t.viewHeight/2.0)
 }
 
 func (t *TileGrid) GetLocation() *Coord {
 	return &t.location
 }
 
-func (t *TileGrid) Drawable() []*tile.PngTile {
+// TilePatch is a loaded tile, or part of one, drawn over an area of the view
+type TilePatch struct {
+	Tile *tile.PngTile
+	// Dst is the area of the view covered, in pixels
+	Dst Rect
+	// Src is the part of the tile drawn, from 0 to 1 across it
+	Src Rect
+}
+
+// Drawable lists what to draw for the visible tiles. Until a tile has loaded
+// its area is covered by its loaded children, else by the matching section
+// of its closest loaded parent, so that zooming never blanks the view.
+// Patches never overlap.
+func (t *TileGrid) Drawable() []TilePatch {
 	c := uint32(t.viewWidth/t.tileWidth+t.viewHeight/t.tileHeight) + 1
-	tiles := make([]*tile.PngTile, 0, c)
-	i := 0
-
-	t.forEachVisibleTile(func(tileCoord tile.TileCoord) {
-		itile, exists := t.cache.Load(tileCoord)
-		if exists {
-			pngTile := itile.(tile.PngTile)
-			tiles = append(tiles, &pngTile)
-			i++
+	patches := make([]TilePatch, 0, c)
+
+	worldWidth, _ := t.worldSize()
+	t.forEachVisibleTile(func(tileCoord tile.TileCoord, wrap int) {
+		x, y := t.ScreenPosition(tileCoord)
+		x += float32(wrap) * worldWidth
+		tileWidth, tileHeight := t.TileSize()
+		dst := Rect{X: x, Y: y, W: tileWidth, H: tileHeight}
+		if pngTile, exists := t.cache.Load(tileCoord); exists {
+			patches = append(patches, TilePatch{Tile: &pngTile, Dst: dst, Src: FullTexture})
+			return
+		}
+
+		// Each quarter of the tile comes from its child when zooming out,
+		// or its parent when zooming in
+		for i := uint32(0); i < 4; i++ {
+			child := tile.TileCoord{
+				X: tileCoord.X*2 + i%2,
+				Y: tileCoord.Y*2 + i/2,
+				Z: tileCoord.Z + 1,
+			}
+			quarter := Rect{
+				X: dst.X + float32(i%2)*dst.W/2.0,
+				Y: dst.Y + float32(i/2)*dst.H/2.0,
+				W: dst.W / 2.0,
+				H: dst.H / 2.0,
+			}
+			if patch, exists := t.parentPatch(child, quarter); exists {
+				patches = append(patches, patch)
+			}
 		}
 	})
 
-	return tiles
+	return patches
+}
+
+// parentPatch finds the tile, or the closest of its parents, which has been
+// loaded, returning the section of it covering the tile
+func (t *TileGrid) parentPatch(coord tile.TileCoord, dst Rect) (TilePatch, bool) {
+	for levels := uint32(0); levels <= coord.Z; levels++ {
+		parent := tile.TileCoord{
+			X: coord.X >> levels,
+			Y: coord.Y >> levels,
+			Z: coord.Z - levels,
+		}
+		// Checked first so that missing parents do not count as cache misses
+		if !t.cache.Contains(parent) {
+			continue
+		}
+		pngTile, exists := t.cache.Load(parent)
+		if !exists {
+			continue
+		}
+
+		scale := 1.0 / float32(uint32(1)<<levels)
+		return TilePatch{
+			Tile: &pngTile,
+			Dst:  dst,
+			Src: Rect{
+				X: float32(coord.X-parent.X<<levels) * scale,
+				Y: float32(coord.Y-parent.Y<<levels) * scale,
+				W: scale,
+				H: scale,
+			},
+		}, true
+	}
+
+	return TilePatch{}, false
 }
 
 func (t *TileGrid) All() []*tile.PngTile {
 	tiles := []*tile.PngTile{}
-	t.cache.Range(func(_, cachedTile interface{}) bool {
-		pngTile := cachedTile.(tile.PngTile)
+	t.cache.Range(func(_ tile.TileCoord, pngTile tile.PngTile) bool {
 		tiles = append(tiles, &pngTile)
 		return true
 	})
@@ -221,25 +852,57 @@ func (t *TileGrid) All() []*tile.PngTile {
 	return tiles
 }
 
+// Close stops the fetch workers and cancels what they are fetching
 func (grid *TileGrid) Close() {
 	log.Printf("grid closing...")
-	close(grid.TilesToLoad)
-	close(grid.TilesToExpire)
-	close(grid.TilesInFlight)
+	grid.queue.Close()
+	grid.CancelLoadingTiles()
+}
+
+// FetchTile fetches the tile from the source, returning nil for both if
+// the fetch was cancelled as the tile was no longer needed
+func (grid *TileGrid) FetchTile(coord tile.TileCoord) (*tile.PngTile, error) {
+	return grid.fetchTile(coord, grid.startFetch(coord))
 }
 
-func (grid *TileGrid) FetchTile(x uint32, y uint32, z uint32, cancel chan func()) (*tile.PngTile, error) {
-	log.Printf("fetching tile (%d, %d, %d)", x, y, z)
+// startFetch marks the tile as being fetched, so that it can be cancelled
+func (grid *TileGrid) startFetch(coord tile.TileCoord) *tileFetch {
+	ctx, cancel := context.WithCancel(context.Background())
+	fetch := &tileFetch{ctx: ctx, cancel: cancel}
+	grid.inFlightMu.Lock()
+	grid.inFlight[coord] = fetch
+	grid.inFlightMu.Unlock()
 
-	ctx, cancelCtx := context.WithCancel(context.Background())
-	go func() {
-		cancel <- cancelCtx
+	return fetch
+}
+
+func (grid *TileGrid) fetchTile(coord tile.TileCoord, fetch *tileFetch) (t *tile.PngTile, err error) {
+	log.Printf("fetching tile (%d, %d, %d)", coord.X, coord.Y, coord.Z)
+
+	defer func() {
+		grid.inFlightMu.Lock()
+		// A cancelled tile may have been requested again in the meantime,
+		// otherwise a tile which failed can be loaded again once it has
+		// waited a while
+		if grid.inFlight[coord] == fetch {
+			delete(grid.inFlight, coord)
+			if t == nil {
+				grid.loading.Delete(coord)
+			}
+		}
+		if err != nil {
+			grid.fetchFailed(coord, err, time.Now())
+		} else if t != nil {
+			delete(grid.failed, coord)
+		}
+		grid.inFlightMu.Unlock()
+		fetch.cancel()
 	}()
 
-	t, err := tile.Tile(ctx, x, y, z)
+	t, err = grid.source.Tile(fetch.ctx, coord)
 	if err != nil {
 		// Cancelled, return empty on both counts
-		if ctx.Err() == context.Canceled {
+		if fetch.ctx.Err() == context.Canceled {
 			return nil, nil
 		}
 
@@ -249,6 +912,39 @@ func (grid *TileGrid) FetchTile(x uint32, y uint32, z uint32, cancel chan func()
 	return t, nil
 }
 
+// fetchFailed puts off fetching the tile again, for longer each time it
+// fails. inFlightMu must be held.
+func (grid *TileGrid) fetchFailed(coord tile.TileCoord, err error, now time.Time) {
+	failure, exists := grid.failed[coord]
+	if !exists {
+		failure = &fetchFailure{}
+		grid.failed[coord] = failure
+	}
+
+	failure.delay *= 2
+	if failure.delay < FETCH_RETRY_DELAY {
+		failure.delay = FETCH_RETRY_DELAY
+	}
+	if failure.delay > FETCH_RETRY_MAX_DELAY || errors.Is(err, tile.ErrTileNotFound) {
+		failure.delay = FETCH_RETRY_MAX_DELAY
+	}
+	failure.retryAt = now.Add(failure.delay)
+}
+
+// canFetch reports whether the tile is not waiting to be tried again after
+// failing to fetch
+func (grid *TileGrid) canFetch(coord tile.TileCoord, now time.Time) bool {
+	grid.inFlightMu.Lock()
+	defer grid.inFlightMu.Unlock()
+
+	failure, exists := grid.failed[coord]
+	return !exists || !now.Before(failure.retryAt)
+}
+
+func (grid *TileGrid) Source() tile.TileSource {
+	return grid.source
+}
+
 func (grid *TileGrid) ViewSize() (width float32, height float32) {
 	width = grid.viewWidth
 	height = grid.viewHeight
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"cartog/tile"
+	"context"
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+// testSource hands out blank tiles for every coordinate in the world
+type testSource struct {
+	info tile.SourceInfo
+}
+
+func newTestSource() *testSource {
+	return &testSource{
+		info: tile.SourceInfo{}.WithDefaults(),
+	}
+}
+
+func (s *testSource) Tile(_ context.Context, coord tile.TileCoord) (*tile.PngTile, error) {
+	if coord.X >= 1<<coord.Z || coord.Y >= 1<<coord.Z {
+		return nil, tile.ErrTileNotFound
+	}
+
+	return &tile.PngTile{
+		Tile:  coord,
+		Image: image.NewRGBA(image.Rect(0, 0, int(s.info.TileSize), int(s.info.TileSize))),
+	}, nil
+}
+
+func (s *testSource) Info() tile.SourceInfo {
+	return s.info
+}
+
+func TestTileGrid_CenterOn(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	grid.CenterOn(-41.2865, 174.7762, 12)
+	if grid.GetLocation().Z != 12 {
+		t.Errorf("expected zoom 12, got %f", grid.GetLocation().Z)
+	}
+
+	lat, lon := grid.Center()
+	if math.Abs(lat+41.2865) > 1e-4 || math.Abs(lon-174.7762) > 1e-4 {
+		t.Errorf("view not centered, got (%f, %f)", lat, lon)
+	}
+
+	// The top left of the view is north west of the center
+	topLat, leftLon := grid.ScreenToLatLon(0, 0)
+	if topLat <= lat || leftLon >= lon {
+		t.Errorf("unexpected top left (%f, %f)", topLat, leftLon)
+	}
+}
+
+func TestTileGrid_ScreenToLatLon(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{X: 0, Y: 0, Z: 0}, 256, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	lat, lon := grid.ScreenToLatLon(128, 128)
+	if math.Abs(lat) > 1e-9 || math.Abs(lon) > 1e-9 {
+		t.Errorf("middle of the world is not (0, 0), got (%f, %f)", lat, lon)
+	}
+	lat, lon = grid.ScreenToLatLon(0, 256)
+	if math.Abs(lat+85.0511) > 1e-4 || math.Abs(lon+180) > 1e-9 {
+		t.Errorf("bottom left of the world is wrong, got (%f, %f)", lat, lon)
+	}
+}
+
+func TestTileGrid_DrawableFallback(t *testing.T) {
+	source := newTestSource()
+	grid, err := newTileGrid(source, Coord{X: 0, Y: 0, Z: 3}, 256, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	load := func(coord tile.TileCoord) {
+		pngTile, _ := source.Tile(context.Background(), coord)
+		grid.SetTile(coord, *pngTile)
+	}
+	patches := func() map[Rect]TilePatch {
+		found := map[Rect]TilePatch{}
+		for _, patch := range grid.Drawable() {
+			found[patch.Dst] = patch
+		}
+		return found
+	}
+
+	if len(grid.Drawable()) != 0 {
+		t.Errorf("nothing loaded but got %v", grid.Drawable())
+	}
+
+	// Zooming in, the top left of the grandparent stands in for the tile
+	load(tile.TileCoord{X: 0, Y: 0, Z: 1})
+	patch := patches()[Rect{X: 128, Y: 128, W: 128, H: 128}]
+	if patch.Tile == nil || patch.Tile.Tile.Z != 1 || patch.Src != (Rect{X: 0.125, Y: 0.125, W: 0.125, H: 0.125}) {
+		t.Errorf("unexpected parent patch %v", patch)
+	}
+
+	// Zooming out, loaded children cover their quarter with the parent
+	// filling in the rest
+	load(tile.TileCoord{X: 1, Y: 0, Z: 4})
+	found := patches()
+	child := found[Rect{X: 128, Y: 0, W: 128, H: 128}]
+	if child.Tile == nil || child.Tile.Tile != (tile.TileCoord{X: 1, Y: 0, Z: 4}) || child.Src != FullTexture {
+		t.Errorf("unexpected child patch %v", child)
+	}
+	if parent := found[Rect{X: 0, Y: 0, W: 128, H: 128}]; parent.Tile == nil || parent.Tile.Tile.Z != 1 {
+		t.Errorf("unexpected parent patch %v", parent)
+	}
+
+	// Once loaded the tile itself is drawn whole
+	load(tile.TileCoord{X: 0, Y: 0, Z: 3})
+	patch = patches()[Rect{X: 0, Y: 0, W: 256, H: 256}]
+	if patch.Tile == nil || patch.Tile.Tile.Z != 3 || patch.Src != FullTexture {
+		t.Errorf("unexpected patch %v", patch)
+	}
+	if _, exists := patches()[Rect{X: 128, Y: 0, W: 128, H: 128}]; exists {
+		t.Errorf("child still drawn over loaded tile")
+	}
+}
+
+func TestTileGrid_FractionalZoom(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(-41.2865, 174.7762, 10)
+
+	// Zooming keeps the middle of the view in place
+	grid.Move(Coord{Z: 0.5})
+	lat, lon := grid.Center()
+	if grid.GetLocation().Z != 10.5 || math.Abs(lat+41.2865) > 1e-4 || math.Abs(lon-174.7762) > 1e-4 {
+		t.Errorf("unexpected zoom %f at (%f, %f)", grid.GetLocation().Z, lat, lon)
+	}
+
+	// In between zoom levels the tiles of the level below are scaled up
+	width, height := grid.TileSize()
+	if math.Abs(float64(width)-256*math.Sqrt2) > 1e-3 || width != height {
+		t.Errorf("unexpected tile size %f x %f", width, height)
+	}
+	grid.forEachVisibleTile(func(coord tile.TileCoord, _ int) {
+		if coord.Z != 10 {
+			t.Errorf("unexpected tile %v at zoom 10.5", coord)
+		}
+	})
+	x, y := grid.ScreenPosition(tile.TileCoord{X: 1000, Y: 600, Z: 10})
+	location := grid.GetLocation()
+	if math.Abs(float64(x+location.X)-1000*float64(width)) > 1 || math.Abs(float64(y+location.Y)-600*float64(height)) > 1 {
+		t.Errorf("unexpected screen position (%f, %f)", x, y)
+	}
+
+	// Zoom stays within what the source has
+	grid.Move(Coord{Z: 100})
+	if grid.GetLocation().Z != MAX_ZOOM {
+		t.Errorf("expected zoom %d, got %f", MAX_ZOOM, grid.GetLocation().Z)
+	}
+}
+
+func TestTileGrid_ZoomBy(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(51.1634, 10.4477, 4)
+	grid.ZoomDuration = 100 * time.Millisecond
+
+	grid.ZoomBy(1)
+	start := time.Now()
+	grid.Animate(start)
+	if !grid.Animate(start.Add(50 * time.Millisecond)) {
+		t.Fatalf("animation finished early")
+	}
+	if z := grid.GetLocation().Z; z <= 4.5 || z >= 5 {
+		t.Errorf("expected to be eased most of the way, at zoom %f", z)
+	}
+
+	// Zooming again carries on from where the first zoom was heading
+	grid.ZoomBy(1)
+	grid.Animate(start)
+	if grid.Animate(start.Add(grid.ZoomDuration)) {
+		t.Errorf("animation not finished")
+	}
+	lat, lon := grid.Center()
+	if grid.GetLocation().Z != 6 || math.Abs(lat-51.1634) > 1e-4 || math.Abs(lon-10.4477) > 1e-4 {
+		t.Errorf("unexpected zoom %f at (%f, %f)", grid.GetLocation().Z, lat, lon)
+	}
+	if grid.Animate(time.Now()) {
+		t.Errorf("animation still going")
+	}
+
+	// Panning part way through a zoom is kept as the zoom carries on
+	grid.ZoomBy(-1)
+	grid.Animate(start)
+	grid.Animate(start.Add(50 * time.Millisecond))
+	lat, lon = grid.ScreenToLatLon(500, 300)
+	grid.Move(Coord{X: 100})
+	grid.Animate(start.Add(grid.ZoomDuration))
+	if gotLat, gotLon := grid.Center(); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("expected the pan to (%f, %f) kept, got (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+
+	// Without a duration zooming happens straight away
+	grid.ZoomDuration = 0
+	grid.ZoomBy(-0.25)
+	if grid.GetLocation().Z != 4.75 {
+		t.Errorf("expected zoom 4.75, got %f", grid.GetLocation().Z)
+	}
+}
+
+func TestTileGrid_ZoomAnchor(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(-41.2865, 174.7762, 10)
+
+	// The point under the cursor stays put, to within a fraction of a
+	// pixel, while the middle moves
+	centerLat, centerLon := grid.Center()
+	lat, lon := grid.ScreenToLatLon(100, 500)
+	grid.MoveAt(Coord{Z: 1.5}, 100, 500)
+	if gotLat, gotLon := grid.ScreenToLatLon(100, 500); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("anchor moved from (%f, %f) to (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+	if gotLat, gotLon := grid.Center(); gotLat == centerLat || gotLon == centerLon {
+		t.Errorf("middle of the view did not move")
+	}
+
+	// As it does when zooming is animated
+	grid.ZoomDuration = 100 * time.Millisecond
+	lat, lon = grid.ScreenToLatLon(700, 50)
+	grid.ZoomByAt(-2, 700, 50)
+	start := time.Now()
+	for _, elapsed := range []time.Duration{0, 30, 60, 100} {
+		grid.Animate(start.Add(elapsed * time.Millisecond))
+		if gotLat, gotLon := grid.ScreenToLatLon(700, 50); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+			t.Errorf("anchor moved from (%f, %f) to (%f, %f)", lat, lon, gotLat, gotLon)
+		}
+	}
+	if grid.GetLocation().Z != 9.5 {
+		t.Errorf("expected zoom 9.5, got %f", grid.GetLocation().Z)
+	}
+}
+
+func TestTileGrid_Fling(t *testing.T) {
+	glide := func(frame time.Duration, frames int) float32 {
+		grid, err := NewTileGrid(newTestSource(), Coord{X: 1000, Y: 1000, Z: 6}, 800, 600)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		grid.FlingFriction = 4
+		grid.Fling(1000, 0)
+
+		now := time.Now()
+		grid.Animate(now)
+		for i := 0; i < frames; i++ {
+			now = now.Add(frame)
+			grid.Animate(now)
+		}
+		if grid.GetLocation().Y != 1000 {
+			t.Errorf("fling went sideways to %f", grid.GetLocation().Y)
+		}
+
+		return grid.GetLocation().X - 1000
+	}
+
+	// The distance covered depends on the time passed, not the frame rate
+	slow, fast := glide(100*time.Millisecond, 5), glide(10*time.Millisecond, 50)
+	if math.Abs(float64(slow-fast)) > 0.1 || math.Abs(float64(slow)-1000*(1-math.Exp(-2))/4) > 0.1 {
+		t.Errorf("expected the same distance at any frame rate, got %f and %f", slow, fast)
+	}
+
+	// Slowing down to a stop short of velocity / friction
+	if stopped := glide(100*time.Millisecond, 100); stopped < 240 || stopped > 250 {
+		t.Errorf("unexpected fling distance %f", stopped)
+	}
+
+	grid, err := NewTileGrid(newTestSource(), Coord{X: 1000, Y: 1000, Z: 6}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.FlingFriction = 4
+	grid.Fling(0, -500)
+	grid.Move(Coord{X: 1})
+	if grid.Animate(time.Now().Add(time.Second)) || grid.GetLocation().Y != 1000 {
+		t.Errorf("dragging did not stop the fling")
+	}
+
+	// Flinging follows the view when it is turned, up the view heading east
+	// when facing east as panning does
+	grid.SetBearing(90)
+	location := *grid.GetLocation()
+	now := time.Now()
+	grid.Fling(0, -500)
+	grid.Animate(now)
+	grid.Animate(now.Add(100 * time.Millisecond))
+	if dX, dY := grid.GetLocation().X-location.X, grid.GetLocation().Y-location.Y; dX <= 0 || math.Abs(float64(dY)) > 1e-3 {
+		t.Errorf("expected to glide east, moved by %f, %f", dX, dY)
+	}
+
+	// Without friction the map never glides
+	grid.FlingFriction = 0
+	grid.Fling(0, -500)
+	if grid.Animate(time.Now().Add(time.Second)) {
+		t.Errorf("fling without friction")
+	}
+}
+
+func TestTileGrid_Bearing(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(-41.2865, 174.7762, 12)
+	lat, lon := grid.Center()
+
+	// Facing east the top of the view is east of the middle
+	grid.SetBearing(90)
+	if gotLat, gotLon := grid.Center(); math.Abs(gotLat-lat) > 1e-6 || math.Abs(gotLon-lon) > 1e-6 {
+		t.Errorf("turning moved the middle to (%f, %f)", gotLat, gotLon)
+	}
+	topLat, topLon := grid.ScreenToLatLon(400, 0)
+	if math.Abs(topLat-lat) > 1e-6 || topLon <= lon {
+		t.Errorf("top of the view at (%f, %f) is not east of (%f, %f)", topLat, topLon, lat, lon)
+	}
+
+	// Panning up the view heads east
+	grid.Move(Coord{Y: -300})
+	if gotLat, gotLon := grid.Center(); math.Abs(gotLat-topLat) > 1e-4 || math.Abs(gotLon-topLon) > 1e-4 {
+		t.Errorf("expected to pan to (%f, %f), got (%f, %f)", topLat, topLon, gotLat, gotLon)
+	}
+
+	// Turning about a point keeps it in place, wrapping the bearing around
+	lat, lon = grid.ScreenToLatLon(100, 500)
+	grid.RotateAt(-100, 100, 500)
+	if grid.Bearing() != 350 {
+		t.Errorf("expected bearing 350, got %f", grid.Bearing())
+	}
+	if gotLat, gotLon := grid.ScreenToLatLon(100, 500); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("anchor moved from (%f, %f) to (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+
+	// The tiles under every corner of the turned view are visible
+	grid.SetBearing(45)
+	visible := map[tile.TileCoord]bool{}
+	grid.forEachVisibleTile(func(coord tile.TileCoord, _ int) {
+		visible[coord] = true
+	})
+	for _, corner := range [][2]float32{{0, 0}, {800, 0}, {0, 600}, {800, 600}} {
+		x, y := grid.screenToWorld(corner[0], corner[1])
+		coord := tile.TileCoord{X: uint32(x / 256), Y: uint32(y / 256), Z: 12}
+		if !visible[coord] {
+			t.Errorf("tile %v under corner %v not visible", coord, corner)
+		}
+	}
+}
+
+func TestTileGrid_Wrap(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	visible := func() map[tile.TileCoord]int {
+		found := map[tile.TileCoord]int{}
+		grid.forEachVisibleTile(func(coord tile.TileCoord, _ int) {
+			found[coord]++
+		})
+		return found
+	}
+
+	// Panning west over the antimeridian carries on into the east of the
+	// world, fetching the tiles there
+	grid.CenterOn(0, -179.9, 4)
+	grid.Move(Coord{X: -40})
+	if _, lon := grid.Center(); lon < 170 || lon > 180 {
+		t.Errorf("expected to wrap to the east, got longitude %f", lon)
+	}
+	for coord, count := range visible() {
+		if coord.X >= 16 || coord.Y >= 16 {
+			t.Errorf("tile %v out of the world", coord)
+		}
+		if count != 1 {
+			t.Errorf("tile %v visible %d times", coord, count)
+		}
+	}
+	for _, coord := range []tile.TileCoord{{X: 15, Y: 7, Z: 4}, {X: 0, Y: 7, Z: 4}} {
+		if _, queued := grid.loading.Load(coord); !queued {
+			t.Errorf("tile %v not queued", coord)
+		}
+	}
+
+	// Zoomed out the world is drawn repeatedly across the view
+	grid.CenterOn(0, 0, 2)
+	grid.SetTile(tile.TileCoord{X: 0, Y: 0, Z: 2}, tile.PngTile{Tile: tile.TileCoord{X: 0, Y: 0, Z: 2}})
+	grid.Resize(2400, 600)
+	grid.SetLocation(*grid.GetLocation())
+	drawn := 0
+	for _, patch := range grid.Drawable() {
+		if patch.Tile.Tile == (tile.TileCoord{X: 0, Y: 0, Z: 2}) {
+			drawn++
+		}
+	}
+	if drawn < 2 {
+		t.Errorf("expected the world drawn more than once, drawn %d times", drawn)
+	}
+
+	// The view stops at the poles
+	grid.Resize(800, 600)
+	grid.CenterOn(80, 0, 4)
+	grid.Move(Coord{Y: -10000})
+	if _, y := grid.ScreenPosition(tile.TileCoord{Z: 4}); y != 0 {
+		t.Errorf("expected the top of the world at the top of the view, got %f", y)
+	}
+	grid.Move(Coord{Y: 100000})
+	if _, y := grid.ScreenPosition(tile.TileCoord{Y: 16, Z: 4}); y != 600 {
+		t.Errorf("expected the bottom of the world at the bottom of the view, got %f", y)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cartog/gesture"
 	"log"
 	"math"
 	"time"
@@ -8,24 +9,64 @@ import (
 	"github.com/go-gl/glfw/v3.3/glfw"
 )
 
+const (
+	// SCROLL_ZOOM_STEP is how many zoom levels a step of the mouse wheel
+	// zooms by
+	SCROLL_ZOOM_STEP = 0.5
+	// ROTATE_STEP is how many degrees a key press turns the map by
+	ROTATE_STEP = 15.0
+	// PAN_STEP is how many pixels the pan actions move the map by, and
+	// FAST_PAN_STEP how many the fast ones do
+	PAN_STEP      = 3.0
+	FAST_PAN_STEP = 30.0
+)
+
+// Movement is a pan of the view by Delta.X, Delta.Y pixels and a zoom by
+// Delta.Z levels. Zooming keeps the point of the view at AnchorX, AnchorY in
+// place when Anchored, otherwise the middle of the view.
+type Movement struct {
+	Delta    Coord   `json:"delta"`
+	Anchored bool    `json:"anchored,omitempty"`
+	AnchorX  float32 `json:"anchor_x,omitempty"`
+	AnchorY  float32 `json:"anchor_y,omitempty"`
+	// Instant zooms straight away rather than easing into the new level,
+	// for zooming which follows the fingers
+	Instant bool `json:"instant,omitempty"`
+	// Fling sets the view gliding on at VelocityX, VelocityY pixels a
+	// second, a zero velocity stopping it
+	Fling     bool    `json:"fling,omitempty"`
+	VelocityX float32 `json:"velocity_x,omitempty"`
+	VelocityY float32 `json:"velocity_y,omitempty"`
+	// Rotate turns the view by that many degrees clockwise about the
+	// anchor, and ResetBearing turns it back to north
+	Rotate       float32 `json:"rotate,omitempty"`
+	ResetBearing bool    `json:"reset_bearing,omitempty"`
+	// Tap and LongPress touch the anchor point
+	Tap       bool `json:"tap,omitempty"`
+	LongPress bool `json:"long_press,omitempty"`
+}
+
+// InputState turns keyboard, mouse and gesture input from the window into
+// movements of the map, queued until the main loop takes them. Keys do
+// whatever action the keymap binds them to. GLFW has no touch input, so the
+// left mouse button stands in for a finger. GLFW calls back on the main
+// thread while polling events, so none of it is safe for concurrent use.
 type InputState struct {
-	MoveDelta            chan Coord
-	mouseButtonAction    glfw.Action
-	mouseButton          glfw.MouseButton
-	mousePosX            float64
-	mousePosY            float64
-	lastPressed          time.Time
-	lastPressedX         float64
-	lastPressedY         float64
-	clicksWithinInterval uint
-	pressed              bool
-}
-
-func NewInputState(w *glfw.Window) (*InputState, error) {
+	window     *glfw.Window
+	movements  []Movement
+	keymap     *Keymap
+	recognizer *gesture.Recognizer
+	mousePosX  float64
+	mousePosY  float64
+	mouseDown  bool
+}
+
+func NewInputState(w *glfw.Window, keymap *Keymap) (*InputState, error) {
 	state := &InputState{
-		MoveDelta:   make(chan Coord),
-		lastPressed: time.Time{},
+		window: w,
+		keymap: keymap,
 	}
+	state.recognizer = gesture.NewRecognizer(state.handleGesture)
 
 	w.SetKeyCallback(state.inputKeypressCallback)
 	w.SetCharCallback(state.inputCharCallback)
@@ -37,121 +78,204 @@ func NewInputState(w *glfw.Window) (*InputState, error) {
 	return state, nil
 }
 
-func (state *InputState) inputCharCallback(_ *glfw.Window, ch rune) {
-	delta := Coord{}
-	switch ch {
-	case '-':
-		delta.Z = -1.0
-	case '+':
-		delta.Z = 1.0
-	default:
-		return
+func (state *InputState) inputCharCallback(w *glfw.Window, ch rune) {
+	if action, ok := state.keymap.CharAction(ch); ok {
+		state.doAction(w, action)
 	}
-
-	state.MoveDelta <- delta
 }
 
 func (state *InputState) inputScrollCallback(_ *glfw.Window, dX, dY float64) {
-	if uint32(dY) == 0 {
+	if dY == 0 {
 		return
 	}
-	state.MoveDelta <- Coord{
-		Z: float32(dY),
-	}
+	// Touchpads scroll by fractions of a step, which zoom as smoothly, about
+	// whatever is under the cursor
+	state.move(Movement{
+		Delta:    Coord{Z: float32(dY) * SCROLL_ZOOM_STEP},
+		Anchored: true,
+		AnchorX:  float32(state.mousePosX),
+		AnchorY:  float32(state.mousePosY),
+	})
 }
 
-func (state *InputState) inputKeypressCallback(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, mods glfw.ModifierKey) {
+func (state *InputState) inputKeypressCallback(w *glfw.Window, key glfw.Key, _ int, action glfw.Action, mods glfw.ModifierKey) {
 	if action == glfw.Release {
 		return
 	}
-	velocity := float32(3.0)
-	if mods&glfw.ModShift != 0 {
-		velocity *= 10.0
+	if bound, ok := state.keymap.KeyAction(key, mods); ok {
+		state.doAction(w, bound)
 	}
+}
 
-	delta := Coord{}
-	switch key {
-	case glfw.KeyLeft:
-		delta.X = -velocity
-	case glfw.KeyRight:
-		delta.X = velocity
-	case glfw.KeyUp:
-		delta.Y = -velocity
-	case glfw.KeyDown:
-		delta.Y = velocity
-	default:
+func (state *InputState) doAction(w *glfw.Window, action Action) {
+	if action == ACTION_QUIT {
+		w.SetShouldClose(true)
+		return
+	}
+	if movement, ok := actionMovement(action); ok {
+		state.move(movement)
 		return
 	}
 
-	state.MoveDelta <- delta
+	log.Printf("%s is not available yet", action)
 }
 
-func (state *InputState) inputMouseButtonCallback(w *glfw.Window, button glfw.MouseButton, action glfw.Action, _ glfw.ModifierKey) {
-	state.mouseButtonAction = action
-	state.mouseButton = button
-	log.Printf("Mouse button action %v button %v lX %f lY %f", state.mouseButtonAction, state.mouseButton, state.lastPressedX-state.mousePosX, state.lastPressedY-state.mousePosY)
-
-	// Check if we have pressed multiple times in the click interval
-	if math.Abs(state.lastPressedX-state.mousePosX) < 10.0 &&
-		math.Abs(state.lastPressedY-state.mousePosY) < 10.0 &&
-		time.Since(state.lastPressed) <= time.Duration(ZOOM_INTERVAL_MS)*time.Millisecond {
+// actionMovement is how an action moves the map, if it does at all
+func actionMovement(action Action) (Movement, bool) {
+	movement := Movement{}
+	switch action {
+	case ACTION_PAN_LEFT:
+		movement.Delta.X = -PAN_STEP
+	case ACTION_PAN_RIGHT:
+		movement.Delta.X = PAN_STEP
+	case ACTION_PAN_UP:
+		movement.Delta.Y = -PAN_STEP
+	case ACTION_PAN_DOWN:
+		movement.Delta.Y = PAN_STEP
+	case ACTION_PAN_LEFT_FAST:
+		movement.Delta.X = -FAST_PAN_STEP
+	case ACTION_PAN_RIGHT_FAST:
+		movement.Delta.X = FAST_PAN_STEP
+	case ACTION_PAN_UP_FAST:
+		movement.Delta.Y = -FAST_PAN_STEP
+	case ACTION_PAN_DOWN_FAST:
+		movement.Delta.Y = FAST_PAN_STEP
+	case ACTION_ZOOM_IN:
+		movement.Delta.Z = 1.0
+	case ACTION_ZOOM_OUT:
+		movement.Delta.Z = -1.0
+	case ACTION_ROTATE_LEFT:
+		movement.Rotate = -ROTATE_STEP
+	case ACTION_ROTATE_RIGHT:
+		movement.Rotate = ROTATE_STEP
+	case ACTION_RESET_NORTH:
+		movement.ResetBearing = true
+	default:
+		return Movement{}, false
+	}
 
-		log.Printf("Multiclick %d, pressed %v", state.clicksWithinInterval, state.pressed)
-		state.clicksWithinInterval++
+	return movement, true
+}
 
-		if state.clicksWithinInterval == 2 {
-			state.MoveDelta <- Coord{
-				Z: 1.0,
-			}
-		}
-	} else {
-		state.clicksWithinInterval = 0
+// inputMouseButtonCallback emulates a finger with the left mouse button
+func (state *InputState) inputMouseButtonCallback(_ *glfw.Window, button glfw.MouseButton, action glfw.Action, _ glfw.ModifierKey) {
+	if button != glfw.MouseButtonLeft {
+		return
 	}
 
-	if action == glfw.Release && button == glfw.MouseButtonLeft {
-		state.lastPressedX = state.mousePosX
-		state.lastPressedY = state.mousePosY
-		state.lastPressed = time.Now()
+	phase := gesture.TouchDown
+	switch action {
+	case glfw.Press:
+		state.mouseDown = true
+	case glfw.Release:
+		state.mouseDown = false
+		phase = gesture.TouchUp
+	default:
+		return
 	}
+	state.touch(phase)
 }
 
-func (state *InputState) inputCursorPosCallback(w *glfw.Window, xpos, ypos float64) {
-	if state.mouseButton != glfw.MouseButtonLeft {
-		goto setMousePos
+func (state *InputState) inputCursorPosCallback(_ *glfw.Window, xpos, ypos float64) {
+	state.mousePosX = xpos
+	state.mousePosY = ypos
+	if state.mouseDown {
+		state.touch(gesture.TouchMove)
 	}
+}
 
-	switch state.mouseButtonAction {
-	case glfw.Release:
-		if state.pressed {
-			state.lastPressedX = xpos
-			state.lastPressedY = ypos
-			state.lastPressed = time.Now()
-		}
-		state.pressed = false
+func (state *InputState) touch(phase gesture.Phase) {
+	state.recognizer.Touch(gesture.TouchEvent{
+		Phase: phase,
+		X:     float32(state.mousePosX),
+		Y:     float32(state.mousePosY),
+		Time:  time.Now(),
+	})
+}
 
-	case glfw.Press:
-		// Was already pressed (Aka Held)
-		if state.pressed {
-			state.MoveDelta <- Coord{
-				X: float32(state.mousePosX - xpos),
-				Y: float32(state.mousePosY - ypos),
-			}
-		} else {
-			// Mouse button was released, but now pressed
-			state.pressed = true
-		}
+// Update recognises gestures which need no input, such as long presses
+func (state *InputState) Update(now time.Time) {
+	state.recognizer.Update(now)
+}
+
+func (state *InputState) handleGesture(event gesture.Event) {
+	if movement, ok := gestureMovement(event); ok {
+		state.move(movement)
 	}
+}
 
-setMousePos:
-	if state.pressed {
-		state.lastPressedX = xpos
-		state.lastPressedY = ypos
-		state.lastPressed = time.Now()
+// gestureMovement is how a gesture moves the map, if it does at all
+func gestureMovement(event gesture.Event) (Movement, bool) {
+	switch event.Kind {
+	case gesture.Press:
+		// Catches the map if it is gliding
+		return Movement{Fling: true}, true
+	case gesture.Pan:
+		// The map follows the fingers, so the view moves the other way
+		return Movement{Delta: Coord{X: -event.DX, Y: -event.DY}}, true
+	case gesture.Fling:
+		return Movement{Fling: true, VelocityX: -event.VelocityX, VelocityY: -event.VelocityY}, true
+	case gesture.Pinch:
+		return Movement{
+			Delta:    Coord{Z: float32(math.Log2(float64(event.Scale)))},
+			Anchored: true,
+			AnchorX:  event.X,
+			AnchorY:  event.Y,
+			Instant:  true,
+		}, true
+	case gesture.Rotate:
+		// Turning the fingers clockwise turns the map with them, so the top
+		// of the view faces further anticlockwise
+		return Movement{
+			Rotate:   -event.Rotation * 180.0 / math.Pi,
+			Anchored: true,
+			AnchorX:  event.X,
+			AnchorY:  event.Y,
+		}, true
+	case gesture.Tap:
+		return Movement{
+			Anchored: true,
+			AnchorX:  event.X,
+			AnchorY:  event.Y,
+			Tap:      true,
+		}, true
+	case gesture.DoubleTap:
+		return Movement{
+			Delta:    Coord{Z: 1.0},
+			Anchored: true,
+			AnchorX:  event.X,
+			AnchorY:  event.Y,
+		}, true
+	case gesture.LongPress:
+		return Movement{
+			Anchored:  true,
+			AnchorX:   event.X,
+			AnchorY:   event.Y,
+			LongPress: true,
+		}, true
 	}
-	state.mousePosX = xpos
-	state.mousePosY = ypos
+
+	return Movement{}, false
+}
+
+func (state *InputState) move(movement Movement) {
+	state.movements = append(state.movements, movement)
+}
+
+// TakeMovements hands over the movements made since they were last taken
+func (state *InputState) TakeMovements() []Movement {
+	movements := state.movements
+	state.movements = nil
+
+	return movements
 }
 
-func (i *InputState) Close() {
-	close(i.MoveDelta)
+// Close stops input from the window
+func (state *InputState) Close() {
+	state.window.SetKeyCallback(nil)
+	state.window.SetCharCallback(nil)
+	state.window.SetMouseButtonCallback(nil)
+	state.window.SetCursorPosCallback(nil)
+	state.window.SetScrollCallback(nil)
+	state.movements = nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cartog/gesture"
+	"math"
+	"testing"
+	"time"
+)
+
+// gestureGrid moves a grid with scripted gestures, as the window would
+func gestureGrid(t *testing.T) (*TileGrid, *gesture.Simulator) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(-41.2865, 174.7762, 10)
+
+	simulator := gesture.NewSimulator(time.Now(), func(event gesture.Event) {
+		if movement, ok := gestureMovement(event); ok {
+			applyMovement(grid, movement)
+		}
+	})
+
+	return grid, simulator
+}
+
+func TestGestureMovement_Pan(t *testing.T) {
+	grid, s := gestureGrid(t)
+	grid.FlingFriction = 4
+
+	// What was under the finger follows it
+	lat, lon := grid.ScreenToLatLon(100, 100)
+	s.Drag(100, 100, 300, 250, 10, time.Second)
+	if gotLat, gotLon := grid.ScreenToLatLon(300, 250); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("expected (%f, %f) under the finger, got (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+	if !grid.Animate(s.Now.Add(10 * time.Millisecond)) {
+		t.Errorf("map not flung")
+	}
+
+	// Touching the map again catches it
+	s.Down(0, 400, 300)
+	if grid.Animate(s.Now.Add(20 * time.Millisecond)) {
+		t.Errorf("map still gliding")
+	}
+}
+
+func TestGestureMovement_Zoom(t *testing.T) {
+	grid, s := gestureGrid(t)
+
+	// Pinching zooms straight away about the point between the fingers
+	lat, lon := grid.ScreenToLatLon(400, 200)
+	s.Down(0, 350, 200)
+	s.Down(1, 450, 200)
+	s.Move(0, 300, 200)
+	s.Move(1, 500, 200)
+	if z := grid.GetLocation().Z; math.Abs(float64(z)-11) > 1e-4 {
+		t.Errorf("expected zoom 11, got %f", z)
+	}
+	if gotLat, gotLon := grid.ScreenToLatLon(400, 200); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("expected (%f, %f) between the fingers, got (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+	s.Up(0, 300, 200)
+	s.Up(1, 500, 200)
+
+	// Double tapping zooms in on the tap
+	s.Wait(time.Second)
+	lat, lon = grid.ScreenToLatLon(600, 450)
+	s.Tap(600, 450)
+	s.Wait(100 * time.Millisecond)
+	s.Tap(600, 450)
+	grid.Animate(s.Now.Add(time.Second))
+	if z := grid.GetLocation().Z; math.Abs(float64(z)-12) > 1e-4 {
+		t.Errorf("expected zoom 12, got %f", z)
+	}
+	if gotLat, gotLon := grid.ScreenToLatLon(600, 450); math.Abs(gotLat-lat) > 1e-4 || math.Abs(gotLon-lon) > 1e-4 {
+		t.Errorf("expected (%f, %f) under the tap, got (%f, %f)", lat, lon, gotLat, gotLon)
+	}
+}
+
+func TestGestureMovement_Rotate(t *testing.T) {
+	grid, s := gestureGrid(t)
+
+	// Turning two fingers clockwise turns the map with them
+	s.Down(0, 300, 300)
+	s.Down(1, 500, 300)
+	s.Move(0, 300+float32(100-100*math.Cos(math.Pi/6)), 300-float32(100*math.Sin(math.Pi/6)))
+	s.Move(1, 500-float32(100-100*math.Cos(math.Pi/6)), 300+float32(100*math.Sin(math.Pi/6)))
+	if bearing := grid.Bearing(); math.Abs(float64(bearing)-330) > 1e-3 {
+		t.Errorf("expected bearing 330, got %f", bearing)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Action is something the user can do from the keyboard, which keys are bound
+// to by name in the config file
+type Action string
+
+const (
+	ACTION_PAN_LEFT       Action = "pan-left"
+	ACTION_PAN_RIGHT      Action = "pan-right"
+	ACTION_PAN_UP         Action = "pan-up"
+	ACTION_PAN_DOWN       Action = "pan-down"
+	ACTION_PAN_LEFT_FAST  Action = "pan-left-fast"
+	ACTION_PAN_RIGHT_FAST Action = "pan-right-fast"
+	ACTION_PAN_UP_FAST    Action = "pan-up-fast"
+	ACTION_PAN_DOWN_FAST  Action = "pan-down-fast"
+	ACTION_ZOOM_IN        Action = "zoom-in"
+	ACTION_ZOOM_OUT       Action = "zoom-out"
+	ACTION_ROTATE_LEFT    Action = "rotate-left"
+	ACTION_ROTATE_RIGHT   Action = "rotate-right"
+	ACTION_RESET_NORTH    Action = "reset-north"
+	ACTION_TOGGLE_LAYER   Action = "toggle-layer"
+	ACTION_SEARCH         Action = "search"
+	ACTION_QUIT           Action = "quit"
+)
+
+// keyActions are all the actions, in the order they are listed
+var keyActions = []struct {
+	action      Action
+	description string
+}{
+	{ACTION_PAN_LEFT, "pan the map left"},
+	{ACTION_PAN_RIGHT, "pan the map right"},
+	{ACTION_PAN_UP, "pan the map up"},
+	{ACTION_PAN_DOWN, "pan the map down"},
+	{ACTION_PAN_LEFT_FAST, "pan the map left ten times as far"},
+	{ACTION_PAN_RIGHT_FAST, "pan the map right ten times as far"},
+	{ACTION_PAN_UP_FAST, "pan the map up ten times as far"},
+	{ACTION_PAN_DOWN_FAST, "pan the map down ten times as far"},
+	{ACTION_ZOOM_IN, "zoom in a level"},
+	{ACTION_ZOOM_OUT, "zoom out a level"},
+	{ACTION_ROTATE_LEFT, "turn the view anticlockwise"},
+	{ACTION_ROTATE_RIGHT, "turn the view clockwise"},
+	{ACTION_RESET_NORTH, "turn the view back to north"},
+	{ACTION_TOGGLE_LAYER, "switch map layer (not available yet)"},
+	{ACTION_SEARCH, "search for a place (not available yet)"},
+	{ACTION_QUIT, "quit cartog"},
+}
+
+// DefaultKeyBindings are the keys bound to each action unless the config file
+// says otherwise
+func DefaultKeyBindings() map[Action][]string {
+	return map[Action][]string{
+		ACTION_PAN_LEFT:       {"left"},
+		ACTION_PAN_RIGHT:      {"right"},
+		ACTION_PAN_UP:         {"up"},
+		ACTION_PAN_DOWN:       {"down"},
+		ACTION_PAN_LEFT_FAST:  {"shift+left"},
+		ACTION_PAN_RIGHT_FAST: {"shift+right"},
+		ACTION_PAN_UP_FAST:    {"shift+up"},
+		ACTION_PAN_DOWN_FAST:  {"shift+down"},
+		ACTION_ZOOM_IN:        {"+"},
+		ACTION_ZOOM_OUT:       {"-"},
+		ACTION_ROTATE_LEFT:    {"q"},
+		ACTION_ROTATE_RIGHT:   {"e"},
+		ACTION_RESET_NORTH:    {"n"},
+		ACTION_QUIT:           {"ctrl+q"},
+	}
+}
+
+// BINDING_MODS are the modifiers which bindings can use, others such as Caps
+// Lock are ignored
+const BINDING_MODS = glfw.ModControl | glfw.ModAlt | glfw.ModSuper | glfw.ModShift
+
+var modifierNames = []struct {
+	name string
+	mod  glfw.ModifierKey
+}{
+	{"ctrl", glfw.ModControl},
+	{"alt", glfw.ModAlt},
+	{"super", glfw.ModSuper},
+	{"shift", glfw.ModShift},
+}
+
+// keyNames are the keys which do not type a character
+var keyNames = map[string]glfw.Key{
+	"left":      glfw.KeyLeft,
+	"right":     glfw.KeyRight,
+	"up":        glfw.KeyUp,
+	"down":      glfw.KeyDown,
+	"escape":    glfw.KeyEscape,
+	"enter":     glfw.KeyEnter,
+	"tab":       glfw.KeyTab,
+	"backspace": glfw.KeyBackspace,
+	"insert":    glfw.KeyInsert,
+	"delete":    glfw.KeyDelete,
+	"home":      glfw.KeyHome,
+	"end":       glfw.KeyEnd,
+	"page-up":   glfw.KeyPageUp,
+	"page-down": glfw.KeyPageDown,
+	"space":     glfw.KeySpace,
+	"f1":        glfw.KeyF1,
+	"f2":        glfw.KeyF2,
+	"f3":        glfw.KeyF3,
+	"f4":        glfw.KeyF4,
+	"f5":        glfw.KeyF5,
+	"f6":        glfw.KeyF6,
+	"f7":        glfw.KeyF7,
+	"f8":        glfw.KeyF8,
+	"f9":        glfw.KeyF9,
+	"f10":       glfw.KeyF10,
+	"f11":       glfw.KeyF11,
+	"f12":       glfw.KeyF12,
+	"kp-enter":  glfw.KeyKPEnter,
+}
+
+// KeyBinding is a key pressed with modifiers. Keys which type a character are
+// bound by Char, following the keyboard layout, unless pressed with Ctrl, Alt
+// or Super when they type nothing and are bound by Key instead.
+type KeyBinding struct {
+	Key  glfw.Key
+	Char rune
+	Mods glfw.ModifierKey
+}
+
+// ParseKeyBinding reads a binding such as "left", "+", "Q" or
+// "ctrl+shift+page-up", modifiers coming before the key
+func ParseKeyBinding(s string) (KeyBinding, error) {
+	parts := strings.Split(s, "+")
+	name := parts[len(parts)-1]
+	mods := parts[:len(parts)-1]
+	// The plus key itself, as in "+" or "ctrl++"
+	if name == "" && len(parts) > 1 && parts[len(parts)-2] == "" {
+		name = "+"
+		mods = parts[:len(parts)-2]
+	}
+	if name == "" {
+		return KeyBinding{}, fmt.Errorf("no key in %q", s)
+	}
+
+	binding := KeyBinding{Key: glfw.KeyUnknown}
+	for _, mod := range mods {
+		found := false
+		for _, modifier := range modifierNames {
+			if strings.EqualFold(mod, modifier.name) {
+				binding.Mods |= modifier.mod
+				found = true
+			}
+		}
+		if !found {
+			return KeyBinding{}, fmt.Errorf("unknown modifier %q in %q", mod, s)
+		}
+	}
+
+	if key, ok := keyNames[strings.ToLower(name)]; ok {
+		binding.Key = key
+		return binding, nil
+	}
+	if utf8.RuneCountInString(name) != 1 {
+		return KeyBinding{}, fmt.Errorf("unknown key %q in %q", name, s)
+	}
+	ch, _ := utf8.DecodeRuneInString(name)
+
+	if binding.Mods&^glfw.ModShift != 0 {
+		// Only letters and digits are the same key on every layout
+		lower := unicode.ToLower(ch)
+		switch {
+		case lower >= 'a' && lower <= 'z':
+			binding.Key = glfw.KeyA + glfw.Key(lower-'a')
+		case lower >= '0' && lower <= '9':
+			binding.Key = glfw.Key0 + glfw.Key(lower-'0')
+		default:
+			return KeyBinding{}, fmt.Errorf("%q can only be bound to a letter or digit", s)
+		}
+		return binding, nil
+	}
+	if binding.Mods == glfw.ModShift {
+		// Shift types the upper case letter, other keys are bound by the
+		// character shifted to
+		if !unicode.IsLetter(ch) {
+			return KeyBinding{}, fmt.Errorf("bind the character typed rather than %q", s)
+		}
+		ch = unicode.ToUpper(ch)
+		binding.Mods = 0
+	}
+	binding.Char = ch
+
+	return binding, nil
+}
+
+func (b KeyBinding) String() string {
+	if b.Char != 0 {
+		return string(b.Char)
+	}
+
+	var name strings.Builder
+	for _, modifier := range modifierNames {
+		if b.Mods&modifier.mod != 0 {
+			name.WriteString(modifier.name + "+")
+		}
+	}
+	switch {
+	case b.Key >= glfw.KeyA && b.Key <= glfw.KeyZ:
+		name.WriteRune('a' + rune(b.Key-glfw.KeyA))
+	case b.Key >= glfw.Key0 && b.Key <= glfw.Key9:
+		name.WriteRune('0' + rune(b.Key-glfw.Key0))
+	default:
+		for keyName, key := range keyNames {
+			if key == b.Key {
+				name.WriteString(keyName)
+			}
+		}
+	}
+
+	return name.String()
+}
+
+// Keymap finds the action bound to each key
+type Keymap struct {
+	bindings map[Action][]KeyBinding
+	actions  map[KeyBinding]Action
+}
+
+// NewKeymap binds the keys listed for each action, as read by ParseKeyBinding.
+// A key may only be bound to one action.
+func NewKeymap(bindings map[Action][]string) (*Keymap, error) {
+	keymap := &Keymap{
+		bindings: map[Action][]KeyBinding{},
+		actions:  map[KeyBinding]Action{},
+	}
+
+	known := map[Action]bool{}
+	for _, info := range keyActions {
+		known[info.action] = true
+	}
+	for action := range bindings {
+		if !known[action] {
+			return nil, fmt.Errorf("unknown action %q", action)
+		}
+	}
+
+	for _, info := range keyActions {
+		for _, key := range bindings[info.action] {
+			binding, err := ParseKeyBinding(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", info.action, err)
+			}
+			if bound, exists := keymap.actions[binding]; exists {
+				return nil, fmt.Errorf("%s is bound to both %s and %s", binding, bound, info.action)
+			}
+			keymap.actions[binding] = info.action
+			keymap.bindings[info.action] = append(keymap.bindings[info.action], binding)
+		}
+	}
+
+	return keymap, nil
+}
+
+// KeyAction is the action bound to a key pressed with mods
+func (k *Keymap) KeyAction(key glfw.Key, mods glfw.ModifierKey) (Action, bool) {
+	action, ok := k.actions[KeyBinding{Key: key, Mods: mods & BINDING_MODS}]
+
+	return action, ok
+}
+
+// CharAction is the action bound to a character typed
+func (k *Keymap) CharAction(ch rune) (Action, bool) {
+	action, ok := k.actions[KeyBinding{Key: glfw.KeyUnknown, Char: ch}]
+
+	return action, ok
+}
+
+// Print lists every action with the keys bound to it
+func (k *Keymap) Print(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, info := range keyActions {
+		keys := make([]string, 0, len(k.bindings[info.action]))
+		for _, binding := range k.bindings[info.action] {
+			keys = append(keys, binding.String())
+		}
+		if len(keys) == 0 {
+			keys = append(keys, "(unbound)")
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", info.action, strings.Join(keys, " "), info.description)
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+func TestParseKeyBinding(t *testing.T) {
+	for _, c := range []struct {
+		binding  string
+		expected KeyBinding
+		name     string
+	}{
+		{"left", KeyBinding{Key: glfw.KeyLeft}, "left"},
+		{"Shift+Page-Up", KeyBinding{Key: glfw.KeyPageUp, Mods: glfw.ModShift}, "shift+page-up"},
+		{"+", KeyBinding{Key: glfw.KeyUnknown, Char: '+'}, "+"},
+		{"q", KeyBinding{Key: glfw.KeyUnknown, Char: 'q'}, "q"},
+		{"shift+q", KeyBinding{Key: glfw.KeyUnknown, Char: 'Q'}, "Q"},
+		{"shift+ctrl+Q", KeyBinding{Key: glfw.KeyQ, Mods: glfw.ModControl | glfw.ModShift}, "ctrl+shift+q"},
+		{"alt+1", KeyBinding{Key: glfw.Key1, Mods: glfw.ModAlt}, "alt+1"},
+	} {
+		binding, err := ParseKeyBinding(c.binding)
+		if err != nil {
+			t.Errorf("%s: %s", c.binding, err)
+			continue
+		}
+		if binding != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.binding, c.expected, binding)
+		}
+		if binding.String() != c.name {
+			t.Errorf("%s: expected to be named %s, got %s", c.binding, c.name, binding)
+		}
+	}
+
+	for _, binding := range []string{"", "ctrl+", "hyper+q", "left-ish", "shift+1", "ctrl+-"} {
+		if _, err := ParseKeyBinding(binding); err == nil {
+			t.Errorf("expected %q to be rejected", binding)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	config := DefaultConfig()
+	config.KeyBindings = map[Action][]string{
+		ACTION_ZOOM_IN: {"+", "=", "ctrl+up"},
+		ACTION_QUIT:    {},
+	}
+	keymap, err := config.Keymap()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if action, _ := keymap.CharAction('='); action != ACTION_ZOOM_IN {
+		t.Errorf("expected = to zoom in, got %q", action)
+	}
+	if action, _ := keymap.KeyAction(glfw.KeyUp, glfw.ModControl|glfw.ModNumLock); action != ACTION_ZOOM_IN {
+		t.Errorf("expected ctrl+up to zoom in, got %q", action)
+	}
+	if action, _ := keymap.KeyAction(glfw.KeyUp, glfw.ModShift); action != ACTION_PAN_UP_FAST {
+		t.Errorf("expected default shift+up to still pan, got %q", action)
+	}
+	if action, ok := keymap.KeyAction(glfw.KeyQ, glfw.ModControl); ok {
+		t.Errorf("expected ctrl+q to be unbound, got %q", action)
+	}
+
+	var printed bytes.Buffer
+	if err := keymap.Print(&printed); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, line := range strings.Split(printed.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == string(ACTION_ZOOM_IN) && strings.Join(fields[1:4], " ") != "+ = ctrl+up" {
+			t.Errorf("unexpected keys listed %q", line)
+		}
+		if len(fields) > 0 && fields[0] == string(ACTION_QUIT) && fields[1] != "(unbound)" {
+			t.Errorf("unexpected keys listed %q", line)
+		}
+	}
+
+	// Keys can only do one thing, and only actions which exist
+	config.KeyBindings = map[Action][]string{ACTION_ZOOM_OUT: {"shift+up"}}
+	if _, err := config.Keymap(); err == nil {
+		t.Errorf("expected a clash with pan-up-fast")
+	}
+	config.KeyBindings = map[Action][]string{"fly": {"f"}}
+	if _, err := config.Keymap(); err == nil {
+		t.Errorf("expected an unknown action")
+	}
+}
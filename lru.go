@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cartog/tile"
+	"container/list"
+	"sync"
+)
+
+const (
+	DEFAULT_CACHE_TILES         = 256
+	DEFAULT_CACHE_TEXTURE_BYTES = 64 * 1024 * 1024
+)
+
+type CacheStats struct {
+	Tiles        int
+	TextureBytes int64
+	Hits         uint64
+	Misses       uint64
+	Evictions    uint64
+}
+
+type cacheEntry struct {
+	coord tile.TileCoord
+	tile  tile.PngTile
+	bytes int64
+}
+
+// tileCache holds loaded tiles, evicting the least recently used ones once
+// it holds more tiles or texture memory than allowed.
+type tileCache struct {
+	mu       sync.Mutex
+	entries  map[tile.TileCoord]*list.Element
+	order    *list.List
+	maxTiles int
+	maxBytes int64
+	stats    CacheStats
+}
+
+func newTileCache(maxTiles int, maxBytes int64) *tileCache {
+	return &tileCache{
+		entries:  map[tile.TileCoord]*list.Element{},
+		order:    list.New(),
+		maxTiles: maxTiles,
+		maxBytes: maxBytes,
+	}
+}
+
+func textureBytes(pngTile *tile.PngTile) int64 {
+	if pngTile.Image == nil {
+		return 0
+	}
+	size := pngTile.Image.Bounds().Size()
+
+	return int64(size.X) * int64(size.Y) * 4
+}
+
+func (c *tileCache) SetLimits(maxTiles int, maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxTiles = maxTiles
+	c.maxBytes = maxBytes
+}
+
+// Load returns the tile and marks it as recently used
+func (c *tileCache) Load(coord tile.TileCoord) (tile.PngTile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[coord]
+	if !exists {
+		c.stats.Misses++
+		return tile.PngTile{}, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cacheEntry).tile, true
+}
+
+// Contains checks for the tile without affecting its recency
+func (c *tileCache) Contains(coord tile.TileCoord) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, exists := c.entries[coord]
+	return exists
+}
+
+func (c *tileCache) Store(coord tile.TileCoord, pngTile tile.PngTile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{
+		coord: coord,
+		tile:  pngTile,
+		bytes: textureBytes(&pngTile),
+	}
+	if elem, exists := c.entries[coord]; exists {
+		c.stats.TextureBytes -= elem.Value.(*cacheEntry).bytes
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[coord] = c.order.PushFront(entry)
+		c.stats.Tiles++
+	}
+	c.stats.TextureBytes += entry.bytes
+}
+
+func (c *tileCache) Delete(coord tile.TileCoord) (tile.PngTile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[coord]
+	if !exists {
+		return tile.PngTile{}, false
+	}
+
+	return c.remove(elem).tile, true
+}
+
+func (c *tileCache) remove(elem *list.Element) *cacheEntry {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.coord)
+	c.stats.Tiles--
+	c.stats.TextureBytes -= entry.bytes
+
+	return entry
+}
+
+// Evict removes the least recently used tiles until the cache is within its
+// limits, never evicting tiles for which keep returns true.
+func (c *tileCache) Evict(keep func(tile.TileCoord) bool) []tile.PngTile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evicted := []tile.PngTile{}
+	elem := c.order.Back()
+	for elem != nil && c.overLimit() {
+		prev := elem.Prev()
+		entry := elem.Value.(*cacheEntry)
+		if !keep(entry.coord) {
+			c.remove(elem)
+			c.stats.Evictions++
+			evicted = append(evicted, entry.tile)
+		}
+		elem = prev
+	}
+
+	return evicted
+}
+
+func (c *tileCache) overLimit() bool {
+	if c.maxTiles > 0 && c.stats.Tiles > c.maxTiles {
+		return true
+	}
+
+	return c.maxBytes > 0 && c.stats.TextureBytes > c.maxBytes
+}
+
+func (c *tileCache) Range(f func(tile.TileCoord, tile.PngTile) bool) {
+	c.mu.Lock()
+	entries := make([]*cacheEntry, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*cacheEntry))
+	}
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		if !f(entry.coord, entry.tile) {
+			return
+		}
+	}
+}
+
+func (c *tileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
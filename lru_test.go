@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cartog/tile"
+	"image"
+	"testing"
+)
+
+func TestTileCache_Evict(t *testing.T) {
+	cache := newTileCache(2, 0)
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+
+	for x := uint32(0); x < 4; x++ {
+		coord := tile.TileCoord{X: x, Y: 0, Z: 2}
+		cache.Store(coord, tile.PngTile{Tile: coord, Image: img})
+	}
+	// Touch the oldest tile so that it becomes the most recently used
+	if _, exists := cache.Load(tile.TileCoord{X: 0, Y: 0, Z: 2}); !exists {
+		t.Fatalf("tile missing from cache")
+	}
+
+	// Tile 1 is the least recently used, but it is still visible
+	evicted := cache.Evict(func(coord tile.TileCoord) bool {
+		return coord.X == 1
+	})
+	if len(evicted) != 2 || evicted[0].Tile.X != 2 || evicted[1].Tile.X != 3 {
+		t.Errorf("unexpected tiles evicted: %v", evicted)
+	}
+
+	stats := cache.Stats()
+	if stats.Tiles != 2 || stats.Evictions != 2 || stats.TextureBytes != 2*256*256*4 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+	if !cache.Contains(tile.TileCoord{X: 0, Y: 0, Z: 2}) || !cache.Contains(tile.TileCoord{X: 1, Y: 0, Z: 2}) {
+		t.Errorf("expected tiles were evicted")
+	}
+}
+
+func TestTileCache_EvictBytes(t *testing.T) {
+	cache := newTileCache(0, 256*256*4)
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+
+	for x := uint32(0); x < 3; x++ {
+		coord := tile.TileCoord{X: x, Y: 0, Z: 2}
+		cache.Store(coord, tile.PngTile{Tile: coord, Image: img})
+	}
+
+	evicted := cache.Evict(func(tile.TileCoord) bool { return false })
+	if len(evicted) != 2 || cache.Stats().Tiles != 1 {
+		t.Errorf("texture byte limit not respected, evicted %d", len(evicted))
+	}
+}
@@ -2,217 +2,261 @@ package main
 
 import (
 	"cartog/tile"
-	"context"
-	"errors"
-	"image"
-	"image/draw"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 )
 
 const (
-	TILE_X           = 256
-	TILE_Y           = 256
-	ZOOM_INTERVAL_MS = 300
-)
+	DEFAULT_ZOOM_DURATION_MS = 250
+	DEFAULT_FLING_FRICTION   = 4.0
 
-var glWorkPipeline = make(chan func())
+	// Where the map starts out, over Europe
+	START_LAT  = 51.1634
+	START_LON  = 10.4477
+	START_ZOOM = 4
+)
 
 func init() {
 	runtime.LockOSThread()
 }
 
-func doWork(f func()) {
-	done := make(chan bool)
-	defer close(done)
-
-	glWorkPipeline <- func() {
-		f()
-		done <- true
+// handleGridMovement moves the grid with the input since the last frame,
+// recording each movement if there is a recorder. It runs on the main loop
+// along with Animate, so that only one goroutine ever moves the grid.
+func handleGridMovement(windowState *WindowState, grid *TileGrid, recorder *Recorder) {
+	for _, movement := range windowState.TakeMovements() {
+		if recorder != nil {
+			if err := recorder.RecordMovement(time.Now(), movement); err != nil {
+				log.Printf("recording failed: %s", err)
+			}
+		}
+		applyMovement(grid, movement)
 	}
-	<-done
 }
 
-func fetchTile(x uint32, y uint32, z uint32, cancel chan func()) (*tile.PngTile, error) {
-	log.Printf("fetching tile (%d, %d, %d)", x, y, z)
-
-	ctx, cancelCtx := context.WithCancel(context.Background())
-	go func() {
-		cancel <- cancelCtx
-	}()
+// applyMovement moves the grid as the input asked
+func applyMovement(grid *TileGrid, movement Movement) {
+	anchorX, anchorY := grid.ViewSize()
+	anchorX /= 2.0
+	anchorY /= 2.0
+	if movement.Anchored {
+		anchorX, anchorY = movement.AnchorX, movement.AnchorY
+	}
 
-	t, err := tile.Tile(ctx, x, y, z)
-	if err != nil {
-		// Cancelled, return empty on both counts
-		if ctx.Err() == context.Canceled {
-			return nil, nil
-		}
+	// Zooming is animated unless following fingers, panning follows the
+	// input straight away
+	delta := movement.Delta
+	if delta.Z != 0 && !movement.Instant {
+		grid.ZoomByAt(delta.Z, anchorX, anchorY)
+		delta.Z = 0
+	}
+	if delta != (Coord{}) {
+		grid.MoveAt(delta, anchorX, anchorY)
+	}
+	if movement.Fling {
+		grid.Fling(movement.VelocityX, movement.VelocityY)
+	}
+	if movement.Rotate != 0 {
+		grid.RotateAt(movement.Rotate, anchorX, anchorY)
+	}
 
-		return nil, err
+	// Tapping the compass turns the map back to north
+	viewWidth, viewHeight := grid.ViewSize()
+	compassTapped := movement.Tap && grid.Bearing() != 0 &&
+		CompassRect(viewWidth, viewHeight).Contains(anchorX, anchorY)
+	if movement.ResetBearing || compassTapped {
+		grid.SetBearing(0)
+	}
+	if movement.LongPress {
+		lat, lon := grid.ScreenToLatLon(anchorX, anchorY)
+		log.Printf("Long press at %f, %f", lat, lon)
 	}
+}
 
-	return t, nil
+// handleTileLoading fetches tiles the grid needs with a pool of workers, then
+// queues them to be uploaded by the main thread
+func handleTileLoading(view *MapView, workers int) {
+	log.Printf("Starting %d tile fetching workers", workers)
+	view.grid.StartFetching(workers, func(pngTile *tile.PngTile) {
+		log.Printf("tile fetched %d %d %d", pngTile.Tile.X, pngTile.Tile.Y, pngTile.Tile.Z)
+
+		// Textures / GL must be done in main thread, which picks the tile
+		// up in the coming frames
+		if err := view.QueueUpload(pngTile); err != nil {
+			log.Printf("texture error: %s", err)
+			view.grid.LoadFailed(pngTile.Tile, err)
+		}
+	})
 }
 
-func loadTexture(pngTile *tile.PngTile) (*uint32, error) {
-	log.Printf("loading texture (%v)", pngTile)
+// newTileSource opens the configured provider, caching tiles from tile
+// servers on disk
+func newTileSource(config *Config) (tile.TileSource, error) {
+	profile, name := config.ProviderProfile(config.Provider)
+	source, err := openProvider(profile)
+	if err != nil {
+		return nil, err
+	}
+	if _, remote := source.(*tile.TileDatasource); !remote {
+		return source, nil
+	}
 
-	rgba := image.NewRGBA(pngTile.Image.Bounds())
-	if rgba.Stride != rgba.Rect.Size().X*4 {
-		return nil, errors.New("unsupported image stride")
+	cacheDir, err := config.TileCacheDir(name)
+	if err != nil {
+		log.Printf("tile cache disabled: %s", err)
+		return source, nil
+	}
+	cache, err := tile.NewDiskCache(source, cacheDir)
+	if err != nil {
+		log.Printf("tile cache disabled: %s", err)
+		return source, nil
 	}
-	draw.Draw(rgba, rgba.Bounds(), pngTile.Image, image.Point{0, 0}, draw.Src)
-
-	var texture uint32
-	gl.Enable(gl.TEXTURE_2D)
-	gl.GenTextures(1, &texture)
-	gl.BindTexture(gl.TEXTURE_2D, texture)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		gl.RGBA,
-		int32(rgba.Rect.Size().X),
-		int32(rgba.Rect.Size().Y),
-		0,
-		gl.RGBA,
-		gl.UNSIGNED_BYTE,
-		gl.Ptr(rgba.Pix))
-
-	return &texture, nil
-}
 
-func drawTile(wState *WindowState, origin *Coord, coord *tile.TileCoord, texture *uint32) {
-	ox := origin.X / float32(TILE_X)
-	oy := origin.Y / float32(TILE_Y)
-
-	// TODO: cache these
-	scaleX := TILE_X / float32(wState.Width)
-	scaleY := TILE_Y / float32(wState.Height)
-
-	// Oh, the fun of the OpenGL coordinate system...
-	x1 := (float32(coord.X) - ox) * scaleX
-	y1 := -(float32(coord.Y) - oy) * scaleY
-	x2 := x1 + scaleX
-	y2 := y1 - scaleY
-	x1 = x1*2.0 - 1.0
-	x2 = x2*2.0 - 1.0
-	y1 = y1*2.0 + 1.0
-	y2 = y2*2.0 + 1.0
-
-	gl.BindTexture(gl.TEXTURE_2D, *texture)
-	gl.Begin(gl.QUADS)
-
-	gl.TexCoord2f(0, 0)
-	gl.Vertex3f(x1, y1, 1)
-	gl.TexCoord2f(1, 0)
-	gl.Vertex3f(x2, y1, 1)
-	gl.TexCoord2f(1, 1)
-	gl.Vertex3f(x2, y2, 1)
-	gl.TexCoord2f(0, 1)
-	gl.Vertex3f(x1, y2, 1)
-
-	gl.End()
+	return cache, nil
 }
 
-func handleGridMovement(windowState *WindowState, grid *TileGrid) {
-	for delta := range windowState.GetMovementDelta() {
-		grid.Move(delta)
-	}
+// renderFrame uploads some of the tiles which have loaded then draws the map
+func renderFrame(view *MapView) {
+	view.UploadPending(MAX_UPLOADS_PER_FRAME, UPLOAD_FRAME_BUDGET)
+	view.DrawFrame()
 }
 
-func handleTileLoading(grid *TileGrid) {
-	log.Printf("Starting tile fetching goroutine")
-	defer grid.Close()
-
-	for t := range grid.TilesToLoad {
-		go func(t tile.TileCoord) {
-			log.Printf("tile fetch %d %d %d", t.X, t.Y, t.Z)
-			pngTile, err := fetchTile(t.X, t.Y, t.Z, grid.TilesInFlight)
-			if err != nil {
-				log.Printf("fetch error: %s", err)
-				return
-			}
-			// When a tile is canceled
-			if pngTile == nil {
-				return
-			}
+// newWindowRenderer opens the window with the configured renderer, trying
+// each of RENDERERS in turn by default.
+func newWindowRenderer(title string, config *Config, keymap *Keymap) (*WindowState, Renderer, error) {
+	var renderers []string
+	switch config.Renderer {
+	case "":
+		renderers = RENDERERS
+	case RENDERER_GLES2, RENDERER_GL33, RENDERER_GL21:
+		renderers = []string{config.Renderer}
+	default:
+		return nil, nil, fmt.Errorf("unknown renderer %q, expected one of %s", config.Renderer, strings.Join(RENDERERS, ", "))
+	}
 
-			// Texture already loaded
-			if pngTile.Texture != nil {
-				return
-			}
+	var err error
+	for _, name := range renderers {
+		var windowState *WindowState
+		windowState, err = NewWindow(title, config.Width, config.Height, config.Fullscreen, name, keymap)
+		if err != nil {
+			log.Printf("%s window failed: %s", name, err)
+			continue
+		}
 
-			// Textures / GL must be done in main thread
-			doWork(func() {
-				log.Printf("Loading GL texture for tile %d %d", pngTile.Tile.X, pngTile.Tile.Y)
-				texture, err := loadTexture(pngTile)
-				if err != nil {
-					return
-				}
-				pngTile.Texture = texture
+		var renderer Renderer
+		switch name {
+		case RENDERER_GLES2:
+			renderer, err = NewGLESRenderer(windowState.Width, windowState.Height)
+		case RENDERER_GL33:
+			renderer, err = NewCoreRenderer(windowState.Width, windowState.Height)
+		default:
+			renderer, err = NewGLRenderer(windowState.Width, windowState.Height)
+		}
+		if err != nil {
+			log.Printf("%s renderer failed: %s", name, err)
+			windowState.Destroy()
+			continue
+		}
 
-				grid.SetTile(t, *pngTile)
-			})
-		}(t)
+		log.Printf("Using %s renderer", name)
+		return windowState, renderer, nil
 	}
+
+	return nil, nil, err
 }
 
-func cleanup(grid *TileGrid) {
-	log.Println("Quitting...")
-	for _, tile := range grid.All() {
-		if tile.Texture == nil {
-			continue
+func main() {
+	if len(os.Args) > 1 {
+		var command func([]string) error
+		switch os.Args[1] {
+		case "download":
+			command = runDownload
+		case "render":
+			command = runRender
+		case "replay":
+			command = runReplay
+		}
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%s", err)
+			}
+			return
 		}
+	}
 
-		gl.DeleteTextures(1, tile.Texture)
+	config, err := parseFlags(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	keymap, err := config.Keymap()
+	if err != nil {
+		log.Fatalf("key bindings: %s", err)
+	}
+	if config.PrintKeyBindings {
+		if err := keymap.Print(os.Stdout); err != nil {
+			log.Fatalf("%s", err)
+		}
+		return
+	}
+	source, err := newTileSource(config)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
 	}
-}
 
-func main() {
 	if err := glfw.Init(); err != nil {
 		panic(err)
 	}
 	defer glfw.Terminate()
 
-	if err := gl.Init(); err != nil {
-		panic(err)
-	}
-
-	windowState, err := NewWindow("Cartog")
+	windowState, renderer, err := newWindowRenderer("Cartog", config, keymap)
 	if err != nil {
-		panic(err)
+		log.Fatalf("%s", err)
 	}
 	defer windowState.Close()
 
-	// TODO: "Current" location
-	origin := Coord{
-		X: 6 * TILE_X,
-		Y: 4 * TILE_Y,
-		Z: 4,
-	}
-	grid, err := NewTileGrid(origin, TILE_X, TILE_Y, windowState.Width, windowState.Height)
+	origin := LatLonToCoord(config.Lat, config.Lon, float32(config.Zoom), source.Info().TileSize)
+	origin.X -= float32(windowState.Width) / 2.0
+	origin.Y -= float32(windowState.Height) / 2.0
+	grid, err := NewTileGrid(source, origin, windowState.Width, windowState.Height)
 	if err != nil {
 		log.Fatalf("%s", err)
 		return
 	}
+	grid.ZoomDuration = time.Duration(config.ZoomDurationMs) * time.Millisecond
+	grid.FlingFriction = float32(config.FlingFriction)
+
+	view := NewMapView(grid, renderer)
+
+	var recorder *Recorder
+	if config.RecordPath != "" {
+		file, err := os.Create(config.RecordPath)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		defer file.Close()
+		if recorder, err = NewRecorder(file, grid, time.Now()); err != nil {
+			log.Fatalf("%s", err)
+		}
+		log.Printf("Recording to %s", config.RecordPath)
+	}
 
 	viewResized := false
 	windowState.SetResizeCallback(func(w, h uint32) {
 		log.Printf("Window resized (%d, %d), resizing grid...", w, h)
-		grid.Resize(w, h)
 		viewResized = true
 	})
 
-	go handleTileLoading(grid)
-	go handleGridMovement(windowState, grid)
+	handleTileLoading(view, config.FetchWorkers)
 
 	frames := 0
 	lastTick := time.Now()
@@ -221,42 +265,32 @@ func main() {
 	for !windowState.Window.ShouldClose() {
 		windowState.Window.SwapBuffers()
 		glfw.PollEvents()
+		windowState.Update(time.Now())
+		handleGridMovement(windowState, grid, recorder)
 
 		if viewResized {
-			newWidth, newHeight := grid.ViewSize()
-			gl.Viewport(0, 0, int32(newWidth), int32(newHeight))
-
+			view.Resize(windowState.Width, windowState.Height)
 			viewResized = false
-		}
-		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-		gl.LoadIdentity()
-
-		// Check for any work in the GL pipeline
-		select {
-		case f := <-glWorkPipeline:
-			f()
-		default:
-		}
-
-		// Draw the map tiles from the cache of loaded textures
-		location := grid.GetLocation()
-		for _, pngTile := range grid.Drawable() {
-			if pngTile == nil {
-				break
-			}
-			if pngTile.Texture == nil {
-				continue
+			if recorder != nil {
+				if err := recorder.RecordResize(time.Now(), windowState.Width, windowState.Height); err != nil {
+					log.Printf("recording failed: %s", err)
+				}
 			}
-			drawTile(windowState, location, &pngTile.Tile, pngTile.Texture)
 		}
+		grid.Animate(time.Now())
+		renderFrame(view)
 
 		frames++
 		if time.Since(lastTick) >= time.Second {
-			log.Printf("FPS: %d", frames)
+			stats := grid.CacheStats()
+			log.Printf("FPS: %d, tiles: %d (%d KiB textures), hits: %d, misses: %d, evictions: %d, fetching: %d, pending uploads: %d",
+				frames, stats.Tiles, stats.TextureBytes/1024, stats.Hits, stats.Misses, stats.Evictions, len(grid.InFlight()), view.PendingUploads())
 			lastTick = time.Now()
 			frames = 0
 		}
 	}
 
-	cleanup(grid)
+	log.Println("Quitting...")
+	view.Close()
+	grid.Close()
 }
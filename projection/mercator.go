@@ -0,0 +1,51 @@
+// Package projection converts between WGS84 latitude/longitude and the
+// spherical Web Mercator pixel coordinates used by slippy map tiles.
+package projection
+
+import (
+	"math"
+)
+
+const (
+	// MaxLatitude is the latitude at which Web Mercator makes the world square
+	MaxLatitude = 85.0511287798066
+	MinLatitude = -MaxLatitude
+)
+
+// WorldSize is the width and height in pixels of the whole world at zoom,
+// which may be fractional.
+func WorldSize(zoom float64, tileSize float64) float64 {
+	return tileSize * math.Exp2(zoom)
+}
+
+func ClampLatitude(lat float64) float64 {
+	return math.Max(MinLatitude, math.Min(MaxLatitude, lat))
+}
+
+// LatLonToPixel converts a WGS84 position to world pixel coordinates at zoom,
+// with the origin at the top left (north west) corner of the map.
+func LatLonToPixel(lat, lon, zoom float64, tileSize float64) (x, y float64) {
+	size := WorldSize(zoom, tileSize)
+	latRad := ClampLatitude(lat) * math.Pi / 180.0
+
+	x = (lon + 180.0) / 360.0 * size
+	y = (1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * size
+
+	return x, y
+}
+
+// PixelToLatLon converts world pixel coordinates at zoom back to WGS84
+func PixelToLatLon(x, y, zoom float64, tileSize float64) (lat, lon float64) {
+	size := WorldSize(zoom, tileSize)
+
+	lon = x/size*360.0 - 180.0
+	n := math.Pi * (1.0 - 2.0*y/size)
+	lat = math.Atan(math.Sinh(n)) * 180.0 / math.Pi
+
+	return lat, lon
+}
+
+// LatLonToTile returns the fractional tile coordinates of a WGS84 position
+func LatLonToTile(lat, lon float64, zoom uint32) (x, y float64) {
+	return LatLonToPixel(lat, lon, float64(zoom), 1)
+}
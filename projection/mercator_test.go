@@ -0,0 +1,53 @@
+package projection
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLatLonToPixel(t *testing.T) {
+	cases := []struct {
+		lat, lon, zoom float64
+		x, y           float64
+	}{
+		{0, 0, 0, 128, 128},
+		{MaxLatitude, -180, 0, 0, 0},
+		{MinLatitude, 180, 1, 512, 512},
+		{0, 0, 1.5, 256 * math.Sqrt2, 256 * math.Sqrt2},
+		// Beyond the poles is clamped to the edge of the map
+		{90, 0, 0, 128, 0},
+	}
+
+	for _, c := range cases {
+		x, y := LatLonToPixel(c.lat, c.lon, c.zoom, 256)
+		if math.Abs(x-c.x) > 1e-6 || math.Abs(y-c.y) > 1e-6 {
+			t.Errorf("(%f, %f) at zoom %f: expected (%f, %f), got (%f, %f)", c.lat, c.lon, c.zoom, c.x, c.y, x, y)
+		}
+	}
+}
+
+func TestPixelToLatLon_RoundTrip(t *testing.T) {
+	positions := [][2]float64{
+		{-41.2865, 174.7762},
+		{51.1634, 10.4477},
+		{0, 0},
+		{-85, -179.9},
+	}
+
+	for _, p := range positions {
+		for _, zoom := range []float64{0, 2.25, 16, 19.7} {
+			x, y := LatLonToPixel(p[0], p[1], zoom, 256)
+			lat, lon := PixelToLatLon(x, y, zoom, 256)
+			if math.Abs(lat-p[0]) > 1e-9 || math.Abs(lon-p[1]) > 1e-9 {
+				t.Errorf("%v at zoom %f came back as (%f, %f)", p, zoom, lat, lon)
+			}
+		}
+	}
+}
+
+func TestLatLonToTile(t *testing.T) {
+	x, y := LatLonToTile(-41.2865, 174.7762, 10)
+	if int(x) != 1009 || int(y) != 641 {
+		t.Errorf("unexpected tile (%f, %f)", x, y)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cartog/projection"
+	"cartog/tile"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parseSize reads an image size such as "1024x768"
+func parseSize(s string) (uint32, uint32, error) {
+	parts := strings.SplitN(strings.ToLower(s), "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", s)
+	}
+	width, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil || width == 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", s)
+	}
+	height, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil || height == 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", s)
+	}
+
+	return uint32(width), uint32(height), nil
+}
+
+// boundsExtent is the top left corner and size in world pixels of the bounds
+// at zoom
+func boundsExtent(bounds tile.Bounds, zoom uint32, tileSize uint32) (x, y, width, height float64) {
+	x1, y1 := projection.LatLonToPixel(bounds.MaxLat, bounds.MinLon, float64(zoom), float64(tileSize))
+	x2, y2 := projection.LatLonToPixel(bounds.MinLat, bounds.MaxLon, float64(zoom), float64(tileSize))
+
+	return x1, y1, x2 - x1, y2 - y1
+}
+
+// fitZoom finds the closest zoom at which all of the bounds fit in the image
+func fitZoom(bounds tile.Bounds, width, height uint32, info tile.SourceInfo) uint32 {
+	for zoom := info.MaxZoom; zoom > info.MinZoom; zoom-- {
+		_, _, w, h := boundsExtent(bounds, zoom, info.TileSize)
+		if w <= float64(width) && h <= float64(height) {
+			return zoom
+		}
+	}
+
+	return info.MinZoom
+}
+
+// renderTileCount is the most tiles an image of the size can cover, wherever
+// the tile boundaries fall
+func renderTileCount(width, height, tileSize uint32) uint64 {
+	across := (uint64(width)+uint64(tileSize)-1)/uint64(tileSize) + 1
+	down := (uint64(height)+uint64(tileSize)-1)/uint64(tileSize) + 1
+
+	return across * down
+}
+
+// RenderMap composes the tiles of a view centred on the bounds into an image,
+// using the same layout as the grid shown in the window. Tiles missing from
+// the source are left transparent. Images needing more tiles than a region
+// download may have are refused, as the tiles are fetched the same way.
+func RenderMap(ctx context.Context, source tile.TileSource, bounds tile.Bounds, zoom uint32, width, height uint32) (*image.RGBA, error) {
+	if err := bounds.Validate(); err != nil {
+		return nil, err
+	}
+	info := source.Info().WithDefaults()
+	if count := renderTileCount(width, height, info.TileSize); count > tile.DefaultMaxDownloadTiles {
+		return nil, fmt.Errorf("%w: a %dx%d image needs up to %d tiles, at most %d may be rendered",
+			tile.ErrTooManyTiles, width, height, count, tile.DefaultMaxDownloadTiles)
+	}
+	x, y, w, h := boundsExtent(bounds, zoom, info.TileSize)
+	origin := Coord{
+		X: float32(x + w/2.0 - float64(width)/2.0),
+		Y: float32(y + h/2.0 - float64(height)/2.0),
+		Z: float32(zoom),
+	}
+	grid, err := newTileGrid(source, origin, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	visible := map[tile.TileCoord]image.Point{}
+	grid.forEachVisibleTile(func(coord tile.TileCoord, wrap int) {
+		// Only the one world is drawn
+		if wrap != 0 {
+			return
+		}
+		sx, sy := grid.ScreenPosition(coord)
+		at := image.Pt(int(math.Floor(float64(sx))), int(math.Floor(float64(sy))))
+		if !image.Rect(at.X, at.Y, at.X+int(info.TileSize), at.Y+int(info.TileSize)).Overlaps(img.Rect) {
+			return
+		}
+		visible[coord] = at
+	})
+
+	var mu sync.Mutex
+	var firstErr error
+	coords := make(chan tile.TileCoord)
+	wg := sync.WaitGroup{}
+	for i := 0; i < tile.DefaultDownloadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for coord := range coords {
+				pngTile, err := source.Tile(ctx, coord)
+
+				mu.Lock()
+				if err == nil {
+					src := pngTile.Image.Bounds()
+					dst := src.Sub(src.Min).Add(visible[coord])
+					draw.Draw(img, dst, pngTile.Image, src.Min, draw.Src)
+				} else if !errors.Is(err, tile.ErrTileNotFound) && firstErr == nil {
+					firstErr = fmt.Errorf("tile %v: %w", coord, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for coord := range visible {
+		coords <- coord
+	}
+	close(coords)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return img, nil
+}
+
+// runRender implements "cartog render", which draws a region of the map to a
+// PNG file without opening a window.
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	bbox := flags.String("bbox", "", "region to render as min_lon,min_lat,max_lon,max_lat")
+	zoom := flags.Int("zoom", -1, "zoom level to render at, defaults to the closest that fits the size")
+	size := flags.String("size", "", "image size such as 1024x768, defaults to the size of the region at the zoom")
+	provider := flags.String("provider", "", "provider profile name, tile server URL template or MBTiles/PMTiles file")
+	output := flags.String("o", "map.png", "PNG file to write")
+	flags.Parse(args)
+
+	if *bbox == "" || (*zoom < 0 && *size == "") {
+		flags.Usage()
+		return errors.New("-bbox and at least one of -zoom or -size are required")
+	}
+	bounds, err := tile.ParseBounds(*bbox)
+	if err != nil {
+		return err
+	}
+	// Reversed bounds would have a negative size, which fits at any zoom
+	if err := bounds.Validate(); err != nil {
+		return err
+	}
+
+	configPath, err := DefaultConfigPath()
+	if err != nil {
+		return err
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if *provider != "" {
+		config.Provider = *provider
+	}
+	source, err := newTileSource(config)
+	if err != nil {
+		return err
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+	info := source.Info().WithDefaults()
+
+	var width, height uint32
+	if *size != "" {
+		if width, height, err = parseSize(*size); err != nil {
+			return err
+		}
+	}
+	renderZoom := uint32(*zoom)
+	if *zoom < 0 {
+		renderZoom = fitZoom(bounds, width, height, info)
+	} else if renderZoom < info.MinZoom || renderZoom > info.MaxZoom {
+		return fmt.Errorf("zoom %d is outside of the provider's range %d-%d", renderZoom, info.MinZoom, info.MaxZoom)
+	}
+	if *size == "" {
+		_, _, w, h := boundsExtent(bounds, renderZoom, info.TileSize)
+		if w > math.MaxUint32 || h > math.MaxUint32 {
+			return fmt.Errorf("%w: region is too large at zoom %d", tile.ErrTooManyTiles, renderZoom)
+		}
+		width, height = uint32(math.Ceil(w)), uint32(math.Ceil(h))
+		if width == 0 || height == 0 {
+			return fmt.Errorf("region is empty at zoom %d", renderZoom)
+		}
+	}
+
+	log.Printf("rendering %dx%d at zoom %d", width, height, renderZoom)
+	img, err := RenderMap(context.Background(), source, bounds, renderZoom, width, height)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cartog/projection"
+	"cartog/tile"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// colorSource hands out tiles filled with a colour unique to each tile
+type colorSource struct {
+	testSource
+}
+
+func tileColor(coord tile.TileCoord) color.RGBA {
+	return color.RGBA{R: uint8(coord.X), G: uint8(coord.Y), B: uint8(coord.Z), A: 255}
+}
+
+func (s *colorSource) Tile(_ context.Context, coord tile.TileCoord) (*tile.PngTile, error) {
+	if coord.X >= 1<<coord.Z || coord.Y >= 1<<coord.Z {
+		return nil, tile.ErrTileNotFound
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(s.info.TileSize), int(s.info.TileSize)))
+	draw.Draw(img, img.Rect, &image.Uniform{C: tileColor(coord)}, image.Point{}, draw.Src)
+
+	return &tile.PngTile{
+		Tile:  coord,
+		Image: img,
+	}, nil
+}
+
+func TestParseSize(t *testing.T) {
+	width, height, err := parseSize("1024x768")
+	if err != nil || width != 1024 || height != 768 {
+		t.Errorf("unexpected size %dx%d: %v", width, height, err)
+	}
+	for _, invalid := range []string{"", "1024", "0x10", "axb"} {
+		if _, _, err := parseSize(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestRenderMap(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	world := tile.Bounds{
+		MinLon: -180,
+		MinLat: projection.MinLatitude,
+		MaxLon: 180,
+		MaxLat: projection.MaxLatitude,
+	}
+
+	if zoom := fitZoom(world, 512, 600, source.Info()); zoom != 1 {
+		t.Errorf("expected the world to fit at zoom 1, got %d", zoom)
+	}
+
+	img, err := RenderMap(context.Background(), source, world, 1, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	cases := []struct {
+		x, y  int
+		coord tile.TileCoord
+	}{
+		{0, 0, tile.TileCoord{X: 0, Y: 0, Z: 1}},
+		{300, 10, tile.TileCoord{X: 1, Y: 0, Z: 1}},
+		{10, 300, tile.TileCoord{X: 0, Y: 1, Z: 1}},
+		{511, 511, tile.TileCoord{X: 1, Y: 1, Z: 1}},
+	}
+	for _, c := range cases {
+		if got := img.RGBAAt(c.x, c.y); got != tileColor(c.coord) {
+			t.Errorf("pixel (%d, %d): expected tile %v, got %v", c.x, c.y, c.coord, got)
+		}
+	}
+
+	// A view larger than the world leaves the outside transparent
+	img, err = RenderMap(context.Background(), source, world, 0, 512, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if img.RGBAAt(10, 10).A != 0 || img.RGBAAt(256, 128) != tileColor(tile.TileCoord{}) {
+		t.Errorf("world not centred in the image")
+	}
+
+	// The whole world at a high zoom is far too many tiles to fetch
+	_, _, w, h := boundsExtent(world, 16, source.Info().TileSize)
+	if _, err := RenderMap(context.Background(), source, world, 16, uint32(w), uint32(h)); !errors.Is(err, tile.ErrTooManyTiles) {
+		t.Errorf("expected too many tiles, got %v", err)
+	}
+
+	// Reversed bounds are refused rather than rendered somewhere
+	reversed := tile.Bounds{MinLon: 174.9, MinLat: -41.3, MaxLon: 174.6, MaxLat: -41.2}
+	if _, err := RenderMap(context.Background(), source, reversed, 10, 256, 256); !errors.Is(err, tile.ErrInvalidBounds) {
+		t.Errorf("expected invalid bounds, got %v", err)
+	}
+}
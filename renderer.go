@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Renderers which can be chosen in the config, where the default is to try
+// them in the order listed, RENDERER_GL21 being the last resort
+const (
+	RENDERER_GLES2 = "gles2"
+	RENDERER_GL33  = "gl33"
+	RENDERER_GL21  = "gl21"
+)
+
+// RENDERERS are all the renderers, in the order they are tried
+var RENDERERS = []string{RENDERER_GLES2, RENDERER_GL33, RENDERER_GL21}
+
+// Texture is a handle to an image uploaded to a Renderer, zero is no texture
+type Texture uint32
+
+// Rect is an area of the view in pixels from its top left corner, or of a
+// texture in coordinates from 0 to 1
+type Rect struct {
+	X float32
+	Y float32
+	W float32
+	H float32
+}
+
+// FullTexture is the whole of a texture
+var FullTexture = Rect{X: 0, Y: 0, W: 1, H: 1}
+
+// Sub is the part of the rectangle given from 0 to 1 across it
+func (r Rect) Sub(part Rect) Rect {
+	return Rect{
+		X: r.X + part.X*r.W,
+		Y: r.Y + part.Y*r.H,
+		W: part.W * r.W,
+		H: part.H * r.H,
+	}
+}
+
+// Rotation turns drawing by Angle radians clockwise about X, Y of the view,
+// the zero value leaving it as it is
+type Rotation struct {
+	Angle float32
+	X     float32
+	Y     float32
+}
+
+// Apply turns the point
+func (r Rotation) Apply(x, y float32) (float32, float32) {
+	if r.Angle == 0 {
+		return x, y
+	}
+
+	sin, cos := math.Sincos(float64(r.Angle))
+	dX, dY := float64(x-r.X), float64(y-r.Y)
+
+	return r.X + float32(dX*cos-dY*sin), r.Y + float32(dX*sin+dY*cos)
+}
+
+// corners are the corners of dst once turned, clockwise from the top left
+func (r Rotation) corners(dst Rect) [4][2]float32 {
+	corners := [4][2]float32{
+		{dst.X, dst.Y},
+		{dst.X + dst.W, dst.Y},
+		{dst.X + dst.W, dst.Y + dst.H},
+		{dst.X, dst.Y + dst.H},
+	}
+	for i := range corners {
+		corners[i][0], corners[i][1] = r.Apply(corners[i][0], corners[i][1])
+	}
+
+	return corners
+}
+
+// Contains reports whether the point is inside the rectangle
+func (r Rect) Contains(x, y float32) bool {
+	return x >= r.X && x < r.X+r.W && y >= r.Y && y < r.Y+r.H
+}
+
+// DrawCall is a call to Renderer.Draw
+type DrawCall struct {
+	Texture  Texture
+	Dst      Rect
+	Src      Rect
+	Rotation Rotation
+}
+
+const (
+	// Each vertex is a position in pixels then a texture coordinate
+	quadVertexFloats = 4
+	quadVertices     = 6
+)
+
+// textureRun is a run of quads drawn with the same texture
+type textureRun struct {
+	texture Texture
+	first   int32
+	count   int32
+}
+
+// quadBatch collects the quads of a frame as triangles for shader based
+// renderers, which draw them all from one buffer with a draw call per run of
+// the same texture
+type quadBatch struct {
+	vertices []float32
+	runs     []textureRun
+	rotation Rotation
+}
+
+// Add queues the src part of the texture stretched over dst
+func (b *quadBatch) Add(texture Texture, dst Rect, src Rect) {
+	// Turned here rather than in the shader so that batches can mix
+	// rotations
+	c := b.rotation.corners(dst)
+	u1, v1 := src.X, src.Y
+	u2, v2 := src.X+src.W, src.Y+src.H
+
+	b.vertices = append(b.vertices,
+		c[0][0], c[0][1], u1, v1,
+		c[1][0], c[1][1], u2, v1,
+		c[2][0], c[2][1], u2, v2,
+		c[0][0], c[0][1], u1, v1,
+		c[2][0], c[2][1], u2, v2,
+		c[3][0], c[3][1], u1, v2,
+	)
+
+	last := len(b.runs) - 1
+	if last >= 0 && b.runs[last].texture == texture {
+		b.runs[last].count += quadVertices
+		return
+	}
+	b.runs = append(b.runs, textureRun{
+		texture: texture,
+		first:   int32(len(b.vertices)/quadVertexFloats - quadVertices),
+		count:   quadVertices,
+	})
+}
+
+// Reset empties the batch for the next frame, keeping the rotation
+func (b *quadBatch) Reset() {
+	b.vertices = b.vertices[:0]
+	b.runs = b.runs[:0]
+}
+
+// Renderer draws the map, all calls must be made from the thread owning the
+// graphics context.
+type Renderer interface {
+	// Upload copies the image into a new texture
+	Upload(img image.Image) (Texture, error)
+	// NewTexture creates an empty texture for images to be uploaded into
+	NewTexture(width, height int) (Texture, error)
+	// UploadRegion copies the image into part of the texture, with its top
+	// left corner at x, y
+	UploadRegion(texture Texture, x, y int, img image.Image) error
+	// Draw draws the src part of the texture stretched over dst, which may
+	// be queued until the frame is flushed
+	Draw(texture Texture, dst Rect, src Rect)
+	// SetRotation turns everything drawn after it, until it is set again
+	SetRotation(rotation Rotation)
+	// Flush finishes drawing the frame
+	Flush()
+	Delete(texture Texture)
+	// Clear starts a new frame
+	Clear()
+	// Resize sets the size of the view in pixels
+	Resize(width, height uint32)
+}
+
+// toRGBA converts the image to tightly packed RGBA, as textures are uploaded
+func toRGBA(img image.Image) (*image.RGBA, error) {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) && rgba.Stride == rgba.Rect.Dx()*4 {
+		return rgba, nil
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	if rgba.Stride != rgba.Rect.Size().X*4 {
+		return nil, errors.New("unsupported image stride")
+	}
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return rgba, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuadBatch(t *testing.T) {
+	var quads quadBatch
+	quads.Add(1, Rect{X: 0, Y: 0, W: 10, H: 10}, FullTexture)
+	quads.Add(1, Rect{X: 10, Y: 0, W: 10, H: 10}, FullTexture)
+	quads.rotation = Rotation{Angle: math.Pi / 2, X: 0, Y: 0}
+	quads.Add(2, Rect{X: 0, Y: 0, W: 10, H: 10}, Rect{X: 0.5, Y: 0, W: 0.5, H: 1})
+
+	// Quads of the same texture are drawn together
+	expected := []textureRun{{texture: 1, first: 0, count: 12}, {texture: 2, first: 12, count: 6}}
+	if len(quads.runs) != len(expected) {
+		t.Fatalf("expected runs %v, got %v", expected, quads.runs)
+	}
+	for i := range expected {
+		if quads.runs[i] != expected[i] {
+			t.Errorf("expected runs %v, got %v", expected, quads.runs)
+		}
+	}
+	if len(quads.vertices) != 18*quadVertexFloats {
+		t.Fatalf("expected 18 vertices, got %d floats", len(quads.vertices))
+	}
+
+	// The top right corner of the turned quad is turned clockwise
+	second := quads.vertices[13*quadVertexFloats : 14*quadVertexFloats]
+	if math.Abs(float64(second[0])) > 1e-4 || math.Abs(float64(second[1]-10)) > 1e-4 || second[2] != 1 || second[3] != 0 {
+		t.Errorf("unexpected vertex %v", second)
+	}
+
+	quads.Reset()
+	if len(quads.vertices) != 0 || len(quads.runs) != 0 || quads.rotation.Angle == 0 {
+		t.Errorf("batch not reset for the next frame")
+	}
+}
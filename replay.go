@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"cartog/tile"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	RECORDING_VERSION = 1
+
+	// REPLAY_FRAME_INTERVAL is how often animations are stepped on when
+	// replaying, as the main loop would each frame
+	REPLAY_FRAME_INTERVAL = time.Second / 60
+	// REPLAY_SETTLE_TIME is the longest animations are left to finish after
+	// the last event of a replay
+	REPLAY_SETTLE_TIME = 10 * time.Second
+)
+
+// RecordingHeader is how the grid was set up when a recording started, the
+// first line of the recording
+type RecordingHeader struct {
+	Version        int     `json:"version"`
+	Width          uint32  `json:"width"`
+	Height         uint32  `json:"height"`
+	Location       Coord   `json:"location"`
+	Bearing        float32 `json:"bearing,omitempty"`
+	ZoomDurationMs int64   `json:"zoom_duration_ms"`
+	FlingFriction  float32 `json:"fling_friction"`
+	TileSize       uint32  `json:"tile_size"`
+	MinZoom        uint32  `json:"min_zoom"`
+	MaxZoom        uint32  `json:"max_zoom"`
+}
+
+// RecordedEvent is a movement of the map, or a resize of the view when Width
+// and Height are set, At after the recording started
+type RecordedEvent struct {
+	At       time.Duration `json:"at"`
+	Movement *Movement     `json:"movement,omitempty"`
+	Width    uint32        `json:"width,omitempty"`
+	Height   uint32        `json:"height,omitempty"`
+}
+
+// Recorder writes the input moving the grid to a file as it happens, one JSON
+// line per event, so that the session can be replayed. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	start   time.Time
+}
+
+// NewRecorder starts recording at start, writing how grid is set up first
+func NewRecorder(w io.Writer, grid *TileGrid, start time.Time) (*Recorder, error) {
+	info := grid.source.Info().WithDefaults()
+	width, height := grid.ViewSize()
+	header := RecordingHeader{
+		Version:        RECORDING_VERSION,
+		Width:          uint32(width),
+		Height:         uint32(height),
+		Location:       *grid.GetLocation(),
+		Bearing:        grid.Bearing(),
+		ZoomDurationMs: grid.ZoomDuration.Milliseconds(),
+		FlingFriction:  grid.FlingFriction,
+		TileSize:       info.TileSize,
+		MinZoom:        info.MinZoom,
+		MaxZoom:        info.MaxZoom,
+	}
+
+	recorder := &Recorder{
+		encoder: json.NewEncoder(w),
+		start:   start,
+	}
+	if err := recorder.encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+func (r *Recorder) record(event RecordedEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.encoder.Encode(event)
+}
+
+// RecordMovement records the movement made at now
+func (r *Recorder) RecordMovement(now time.Time, movement Movement) error {
+	return r.record(RecordedEvent{At: now.Sub(r.start), Movement: &movement})
+}
+
+// RecordResize records the view being resized at now
+func (r *Recorder) RecordResize(now time.Time, width, height uint32) error {
+	return r.record(RecordedEvent{At: now.Sub(r.start), Width: width, Height: height})
+}
+
+// Recording is a recorded session, read by ReadRecording
+type Recording struct {
+	Header RecordingHeader
+	Events []RecordedEvent
+}
+
+// ReadRecording reads a recording written by a Recorder
+func ReadRecording(r io.Reader) (*Recording, error) {
+	recording := &Recording{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line == 1 {
+			if err := json.Unmarshal(scanner.Bytes(), &recording.Header); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if recording.Header.Version != RECORDING_VERSION {
+				return nil, fmt.Errorf("unsupported recording version %d", recording.Header.Version)
+			}
+			continue
+		}
+
+		var event RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if n := len(recording.Events); n > 0 && event.At < recording.Events[n-1].At {
+			return nil, fmt.Errorf("line %d: event out of order", line)
+		}
+		recording.Events = append(recording.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, errors.New("empty recording")
+	}
+
+	return recording, nil
+}
+
+// recordedSource stands in for the source a recording was made with, having
+// its tile size and zoom levels but no tiles
+type recordedSource struct {
+	info tile.SourceInfo
+}
+
+func (s *recordedSource) Tile(_ context.Context, _ tile.TileCoord) (*tile.PngTile, error) {
+	return nil, tile.ErrTileNotFound
+}
+
+func (s *recordedSource) Info() tile.SourceInfo {
+	return s.info
+}
+
+// Replay plays the recording against a grid with no tiles, set up as it was
+// when recording started. Animations are stepped every frameInterval as the
+// main loop would, with frame called after each step if given, and left to
+// finish once the events run out. Replaying the same recording always ends
+// the same way.
+func (r *Recording) Replay(frameInterval time.Duration, frame func(at time.Duration, grid *TileGrid)) (*TileGrid, error) {
+	if frameInterval <= 0 {
+		return nil, errors.New("frame interval must be positive")
+	}
+
+	source := &recordedSource{
+		info: tile.SourceInfo{
+			MinZoom:  r.Header.MinZoom,
+			MaxZoom:  r.Header.MaxZoom,
+			TileSize: r.Header.TileSize,
+		}.WithDefaults(),
+	}
+	grid, err := newTileGrid(source, r.Header.Location, r.Header.Width, r.Header.Height)
+	if err != nil {
+		return nil, err
+	}
+	grid.bearing = r.Header.Bearing
+	grid.ZoomDuration = time.Duration(r.Header.ZoomDurationMs) * time.Millisecond
+	grid.FlingFriction = r.Header.FlingFriction
+
+	// Any fixed time will do, so long as it is not the zero time
+	start := time.Unix(0, 0)
+	at := time.Duration(0)
+	step := func() bool {
+		at += frameInterval
+		animating := grid.Animate(start.Add(at))
+		if frame != nil {
+			frame(at, grid)
+		}
+		return animating
+	}
+
+	for _, event := range r.Events {
+		for at+frameInterval <= event.At {
+			step()
+		}
+		if event.Movement != nil {
+			applyMovement(grid, *event.Movement)
+		} else if event.Width > 0 && event.Height > 0 {
+			grid.Resize(event.Width, event.Height)
+		}
+	}
+	for settled := at; at-settled < REPLAY_SETTLE_TIME; {
+		if !step() {
+			break
+		}
+	}
+
+	return grid, nil
+}
+
+// runReplay implements "cartog replay", which plays a recording made with
+// --record without opening a window, printing where the map ends up.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: cartog replay [flags] recording\n")
+		flags.PrintDefaults()
+	}
+	interval := flags.Duration("frame", REPLAY_FRAME_INTERVAL, "time between frames")
+	trace := flags.Bool("trace", false, "print where the map is every frame")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a recording is required")
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	recording, err := ReadRecording(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+
+	var frame func(time.Duration, *TileGrid)
+	if *trace {
+		frame = func(at time.Duration, grid *TileGrid) {
+			fmt.Printf("%s\t%s\n", at, describeGrid(grid))
+		}
+	}
+	grid, err := recording.Replay(*interval, frame)
+	if err != nil {
+		return err
+	}
+	fmt.Println(describeGrid(grid))
+
+	return nil
+}
+
+// describeGrid is where the middle of the view is, the zoom and the bearing
+func describeGrid(grid *TileGrid) string {
+	lat, lon := grid.Center()
+
+	return fmt.Sprintf("lat %.6f lon %.6f zoom %.3f bearing %.1f", lat, lon, grid.GetLocation().Z, grid.Bearing())
+}
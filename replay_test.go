@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"cartog/gesture"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zoomRecording zooms in about a point near the top right of the view, zooms
+// in further before the first zoom has finished, then pans there and back
+const zoomRecording = `{"version":1,"width":800,"height":600,"location":{"X":130672,"Y":130772,"Z":10},"zoom_duration_ms":250,"fling_friction":4,"tile_size":256,"min_zoom":0,"max_zoom":19}
+{"at":100000000,"movement":{"delta":{"X":0,"Y":0,"Z":1},"anchored":true,"anchor_x":600,"anchor_y":150}}
+{"at":200000000,"movement":{"delta":{"X":0,"Y":0,"Z":0.5},"anchored":true,"anchor_x":600,"anchor_y":150}}
+{"at":1000000000,"movement":{"delta":{"X":-40,"Y":25,"Z":0}}}
+{"at":1100000000,"movement":{"delta":{"X":40,"Y":-25,"Z":0}}}
+`
+
+func TestReplay_Regression(t *testing.T) {
+	recording, err := ReadRecording(strings.NewReader(zoomRecording))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// The point zoomed about stays put throughout the zoom
+	var anchorLat, anchorLon float64
+	grid, err := recording.Replay(REPLAY_FRAME_INTERVAL, func(at time.Duration, grid *TileGrid) {
+		lat, lon := grid.ScreenToLatLon(600, 150)
+		if at == REPLAY_FRAME_INTERVAL {
+			anchorLat, anchorLon = lat, lon
+		} else if at < time.Second && (math.Abs(lat-anchorLat) > 1e-4 || math.Abs(lon-anchorLon) > 1e-4) {
+			t.Errorf("%s: anchor moved from (%f, %f) to (%f, %f)", at, anchorLat, anchorLon, lat, lon)
+		}
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if z := grid.GetLocation().Z; z != 11.5 {
+		t.Errorf("expected zoom 11.5, got %f", z)
+	}
+	if lat, lon := grid.ScreenToLatLon(600, 150); math.Abs(lat-anchorLat) > 1e-4 || math.Abs(lon-anchorLon) > 1e-4 {
+		t.Errorf("expected (%f, %f) back under the anchor, got (%f, %f)", anchorLat, anchorLon, lat, lon)
+	}
+}
+
+func TestReplay_RecordedSession(t *testing.T) {
+	grid, err := NewTileGrid(newTestSource(), Coord{Z: 4}, 800, 600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.CenterOn(-41.2865, 174.7762, 10)
+	grid.ZoomDuration = 250 * time.Millisecond
+	grid.FlingFriction = 4
+
+	// Gestures move the grid live, as the window would, while being recorded
+	var recorded bytes.Buffer
+	start := time.Now()
+	recorder, err := NewRecorder(&recorded, grid, start)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	s := gesture.NewSimulator(start, func(event gesture.Event) {
+		if movement, ok := gestureMovement(event); ok {
+			if err := recorder.RecordMovement(event.Time, movement); err != nil {
+				t.Fatalf("%s", err)
+			}
+			applyMovement(grid, movement)
+		}
+	})
+	frames := func(d time.Duration) {
+		for end := s.Now.Add(d); s.Now.Before(end); {
+			s.Wait(10 * time.Millisecond)
+			grid.Animate(s.Now)
+		}
+	}
+
+	s.Drag(100, 100, 300, 250, 10, 200*time.Millisecond)
+	frames(3 * time.Second)
+	s.Tap(400, 300)
+	s.Wait(100 * time.Millisecond)
+	s.Tap(400, 300)
+	frames(time.Second)
+	if err := recorder.RecordResize(s.Now, 600, 400); err != nil {
+		t.Fatalf("%s", err)
+	}
+	grid.Resize(600, 400)
+	s.Drag(500, 300, 450, 320, 5, 500*time.Millisecond)
+	frames(3 * time.Second)
+	liveLat, liveLon := grid.Center()
+
+	recording, err := ReadRecording(&recorded)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	replayed, err := recording.Replay(REPLAY_FRAME_INTERVAL, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// Flings stop on whichever frame they slow down enough, so the replay
+	// may be a pixel or two out
+	lat, lon := replayed.Center()
+	if math.Abs(lat-liveLat) > 1e-3 || math.Abs(lon-liveLon) > 1e-3 {
+		t.Errorf("replay ended at (%f, %f), live at (%f, %f)", lat, lon, liveLat, liveLon)
+	}
+	if replayed.GetLocation().Z != grid.GetLocation().Z {
+		t.Errorf("replay ended at zoom %f, live at %f", replayed.GetLocation().Z, grid.GetLocation().Z)
+	}
+	if width, height := replayed.ViewSize(); width != 600 || height != 400 {
+		t.Errorf("replay not resized, %fx%f", width, height)
+	}
+
+	// Replays always end the same way
+	again, err := recording.Replay(REPLAY_FRAME_INTERVAL, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if *again.GetLocation() != *replayed.GetLocation() {
+		t.Errorf("replays differ, %v and %v", *again.GetLocation(), *replayed.GetLocation())
+	}
+}
+
+func TestReadRecording_Invalid(t *testing.T) {
+	for _, recording := range []string{
+		"",
+		`{"version":2}`,
+		`{"version":1}` + "\n" + `{"at":2}` + "\n" + `{"at":1}`,
+		`{"version":1}` + "\nnot json",
+	} {
+		if _, err := ReadRecording(strings.NewReader(recording)); err == nil {
+			t.Errorf("expected %q to be rejected", recording)
+		}
+	}
+}
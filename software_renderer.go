@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+var _ Renderer = (*SoftwareRenderer)(nil)
+
+// SoftwareRenderer draws into an in memory image with no GPU, recording the
+// calls made so that frames can be checked in tests.
+type SoftwareRenderer struct {
+	Frame *image.RGBA
+	// Calls are the draw calls made since the frame was last cleared
+	Calls      []DrawCall
+	Background color.RGBA
+	textures   map[Texture]*image.RGBA
+	next       Texture
+	rotation   Rotation
+}
+
+func NewSoftwareRenderer(width, height uint32) *SoftwareRenderer {
+	renderer := &SoftwareRenderer{
+		textures: map[Texture]*image.RGBA{},
+	}
+	renderer.Resize(width, height)
+
+	return renderer
+}
+
+func (r *SoftwareRenderer) Upload(img image.Image) (Texture, error) {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	r.next++
+	r.textures[r.next] = rgba
+
+	return r.next, nil
+}
+
+func (r *SoftwareRenderer) NewTexture(width, height int) (Texture, error) {
+	r.next++
+	r.textures[r.next] = image.NewRGBA(image.Rect(0, 0, width, height))
+
+	return r.next, nil
+}
+
+func (r *SoftwareRenderer) UploadRegion(texture Texture, x, y int, img image.Image) error {
+	rgba, ok := r.textures[texture]
+	if !ok {
+		return fmt.Errorf("no texture %d", texture)
+	}
+	size := img.Bounds().Size()
+	draw.Draw(rgba, image.Rect(x, y, x+size.X, y+size.Y), img, img.Bounds().Min, draw.Src)
+
+	return nil
+}
+
+// Draw copies the texture with nearest neighbour sampling, blending in any
+// translucent pixels
+func (r *SoftwareRenderer) Draw(texture Texture, dst Rect, src Rect) {
+	r.Calls = append(r.Calls, DrawCall{Texture: texture, Dst: dst, Src: src, Rotation: r.rotation})
+
+	img, ok := r.textures[texture]
+	if !ok || dst.W <= 0 || dst.H <= 0 {
+		return
+	}
+	size := img.Rect.Size()
+
+	// Each pixel the turned quad may cover is turned back to find where it
+	// is in dst
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range r.rotation.corners(dst) {
+		minX, minY = math.Min(minX, float64(corner[0])), math.Min(minY, float64(corner[1]))
+		maxX, maxY = math.Max(maxX, float64(corner[0])), math.Max(maxY, float64(corner[1]))
+	}
+	unturn := Rotation{Angle: -r.rotation.Angle, X: r.rotation.X, Y: r.rotation.Y}
+
+	area := image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	).Intersect(r.Frame.Rect)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			pX, pY := unturn.Apply(float32(x)+0.5, float32(y)+0.5)
+			if pX < dst.X || pX >= dst.X+dst.W || pY < dst.Y || pY >= dst.Y+dst.H {
+				continue
+			}
+			u := src.X + (pX-dst.X)/dst.W*src.W
+			v := src.Y + (pY-dst.Y)/dst.H*src.H
+			tx := clampInt(int(u*float32(size.X)), 0, size.X-1)
+			ty := clampInt(int(v*float32(size.Y)), 0, size.Y-1)
+			r.blend(x, y, img.RGBAAt(tx, ty))
+		}
+	}
+}
+
+// blend draws the premultiplied colour over the pixel
+func (r *SoftwareRenderer) blend(x, y int, c color.RGBA) {
+	switch c.A {
+	case 0xff:
+		r.Frame.SetRGBA(x, y, c)
+	case 0:
+	default:
+		under := r.Frame.RGBAAt(x, y)
+		keep := uint32(0xff - c.A)
+		r.Frame.SetRGBA(x, y, color.RGBA{
+			R: c.R + uint8(uint32(under.R)*keep/0xff),
+			G: c.G + uint8(uint32(under.G)*keep/0xff),
+			B: c.B + uint8(uint32(under.B)*keep/0xff),
+			A: c.A + uint8(uint32(under.A)*keep/0xff),
+		})
+	}
+}
+
+func (r *SoftwareRenderer) SetRotation(rotation Rotation) {
+	r.rotation = rotation
+}
+
+func (r *SoftwareRenderer) Flush() {}
+
+func (r *SoftwareRenderer) Delete(texture Texture) {
+	delete(r.textures, texture)
+}
+
+func (r *SoftwareRenderer) Clear() {
+	r.Calls = r.Calls[:0]
+	draw.Draw(r.Frame, r.Frame.Rect, &image.Uniform{C: r.Background}, image.Point{}, draw.Src)
+}
+
+func (r *SoftwareRenderer) Resize(width, height uint32) {
+	r.Frame = image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+}
+
+// Textures is how many textures are currently uploaded
+func (r *SoftwareRenderer) Textures() int {
+	return len(r.textures)
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,260 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCacheMaxAge is how long tiles are kept fresh when the tile server
+	// gives no expiry, which matches the minimum asked for by the OSM tile policy.
+	DefaultCacheMaxAge = 7 * 24 * time.Hour
+)
+
+var _ RawTileSource = (*DiskCache)(nil)
+
+// DiskCache wraps a tile source, keeping the tiles it fetches on disk in a
+// z/x/y layout so that they survive restarts and can be used while offline.
+type DiskCache struct {
+	Source TileSource
+	Dir    string
+	MaxAge time.Duration
+}
+
+type cacheMeta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// DefaultCacheDir is the directory tiles of the named provider are cached in,
+// under the XDG cache directory (or the platform equivalent).
+func DefaultCacheDir(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "cartog", "tiles", name), nil
+}
+
+func NewDiskCache(source TileSource, dir string) (*DiskCache, error) {
+	if source == nil {
+		return nil, fmt.Errorf("disk cache needs a tile source")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DiskCache{
+		Source: source,
+		Dir:    dir,
+		MaxAge: DefaultCacheMaxAge,
+	}, nil
+}
+
+func (c *DiskCache) Info() SourceInfo {
+	return c.Source.Info()
+}
+
+func (c *DiskCache) Tile(ctx context.Context, coord TileCoord) (*PngTile, error) {
+	raw, err := c.RawTile(ctx, coord, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPngTile(coord.X, coord.Y, coord.Z, raw.Data)
+}
+
+// RawTile returns the cached tile while it is fresh, otherwise revalidates or
+// refetches it from the source. Stale tiles are returned if the source fails.
+func (c *DiskCache) RawTile(ctx context.Context, coord TileCoord, _ *RawTile) (*RawTile, error) {
+	cached, err := c.Load(coord)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("tile cache read error %v: %s", coord, err)
+	}
+	if cached != nil && time.Now().Before(cached.Expires) {
+		return cached, nil
+	}
+
+	raw, err := c.fetch(ctx, coord, cached)
+	if err != nil {
+		if cached != nil && ctx.Err() == nil {
+			log.Printf("serving stale tile %v: %s", coord, err)
+			return cached, nil
+		}
+
+		return nil, err
+	}
+
+	if err := c.Store(raw); err != nil {
+		log.Printf("tile cache write error %v: %s", coord, err)
+	}
+
+	return raw, nil
+}
+
+func (c *DiskCache) fetch(ctx context.Context, coord TileCoord, cached *RawTile) (*RawTile, error) {
+	raw, err := fetchRawTile(ctx, c.Source, coord, cached)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.Expires.IsZero() {
+		raw.Expires = time.Now().Add(c.MaxAge)
+	}
+
+	return raw, nil
+}
+
+func (c *DiskCache) path(coord TileCoord, ext string) string {
+	return filepath.Join(
+		c.Dir,
+		strconv.FormatUint(uint64(coord.Z), 10),
+		strconv.FormatUint(uint64(coord.X), 10),
+		strconv.FormatUint(uint64(coord.Y), 10)+ext)
+}
+
+// Has reports whether the tile is in the cache, regardless of freshness
+func (c *DiskCache) Has(coord TileCoord) bool {
+	_, err := os.Stat(c.path(coord, ".tile"))
+	return err == nil
+}
+
+// Load reads the tile from the cache, regardless of freshness
+func (c *DiskCache) Load(coord TileCoord) (*RawTile, error) {
+	data, err := ioutil.ReadFile(c.path(coord, ".tile"))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &RawTile{
+		Tile: coord,
+		Data: data,
+	}
+
+	// Tiles missing their metadata are just treated as stale
+	metaBytes, err := ioutil.ReadFile(c.path(coord, ".json"))
+	if err != nil {
+		return raw, nil
+	}
+	meta := cacheMeta{}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return raw, nil
+	}
+	raw.ETag = meta.ETag
+	raw.LastModified = meta.LastModified
+	raw.Expires = meta.Expires
+
+	return raw, nil
+}
+
+// fetchRawTile fetches the encoded tile from the source, encoding it as PNG
+// for sources which only hand out decoded tiles.
+func fetchRawTile(ctx context.Context, source TileSource, coord TileCoord, prev *RawTile) (*RawTile, error) {
+	if rawSource, ok := source.(RawTileSource); ok {
+		return rawSource.RawTile(ctx, coord, prev)
+	}
+
+	pngTile, err := source.Tile(ctx, coord)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, pngTile.Image); err != nil {
+		return nil, err
+	}
+
+	return &RawTile{
+		Tile: coord,
+		Data: buf.Bytes(),
+	}, nil
+}
+
+// Store writes the tile and its validators to the cache
+func (c *DiskCache) Store(raw *RawTile) error {
+	tilePath := c.path(raw.Tile, ".tile")
+	if err := os.MkdirAll(filepath.Dir(tilePath), 0755); err != nil {
+		return err
+	}
+
+	expires := raw.Expires
+	if expires.IsZero() {
+		expires = time.Now().Add(c.MaxAge)
+	}
+	meta, err := json.Marshal(cacheMeta{
+		ETag:         raw.ETag,
+		LastModified: raw.LastModified,
+		Expires:      expires,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Unchanged tiles only need their metadata refreshed
+	if !raw.NotModified || !c.Has(raw.Tile) {
+		if err := writeFileAtomic(tilePath, raw.Data); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(c.path(raw.Tile, ".json"), meta)
+}
+
+// writeFileAtomic makes sure concurrent readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// expiresFromHeader works out when a response goes stale, preferring the
+// Cache-Control max-age over the Expires header. Zero means unknown.
+func expiresFromHeader(header http.Header, now time.Time) time.Time {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return now
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64)
+			if err == nil {
+				return now.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates such as "0" mean already expired
+			return now
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,91 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestTileServer(t *testing.T, cacheControl string) (*httptest.Server, *int32, *int32) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", cacheControl)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(buf.Bytes())
+	}))
+
+	return server, &requests, &notModified
+}
+
+func TestDiskCache_Fresh(t *testing.T) {
+	server, requests, _ := newTestTileServer(t, "max-age=3600")
+	defer server.Close()
+
+	cache, err := NewDiskCache(&TileDatasource{BaseURL: server.URL, Client: server.Client()}, t.TempDir())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	coord := TileCoord{X: 1, Y: 2, Z: 3}
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Tile(context.Background(), coord); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if *requests != 1 {
+		t.Errorf("fresh tile fetched %d times", *requests)
+	}
+	if !cache.Has(coord) {
+		t.Errorf("tile not stored in cache")
+	}
+}
+
+func TestDiskCache_Revalidate(t *testing.T) {
+	server, requests, notModified := newTestTileServer(t, "max-age=0")
+	defer server.Close()
+
+	cache, err := NewDiskCache(&TileDatasource{BaseURL: server.URL, Client: server.Client()}, t.TempDir())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	coord := TileCoord{X: 1, Y: 2, Z: 3}
+	for i := 0; i < 2; i++ {
+		tile, err := cache.Tile(context.Background(), coord)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tile.Image == nil {
+			t.Fatalf("tile image missing")
+		}
+	}
+	if *requests != 2 || *notModified != 1 {
+		t.Errorf("stale tile not revalidated, %d requests, %d not modified", *requests, *notModified)
+	}
+
+	// The server going away should leave us with the stale copy
+	server.Close()
+	if _, err := cache.Tile(context.Background(), coord); err != nil {
+		t.Errorf("stale tile not served while offline: %s", err)
+	}
+	if _, err := cache.Tile(context.Background(), TileCoord{X: 0, Y: 0, Z: 1}); err == nil {
+		t.Errorf("uncached tile served while offline")
+	}
+}
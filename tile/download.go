@@ -0,0 +1,260 @@
+package tile
+
+import (
+	"cartog/projection"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultDownloadConcurrency is the most connections the OSM tile usage
+	// policy allows a client to make at once.
+	DefaultDownloadConcurrency = 2
+	// DefaultMaxDownloadTiles caps the size of a single region download, as
+	// bulk downloading is not allowed from the public OSM tile servers.
+	DefaultMaxDownloadTiles = 10000
+	// EstimatedTileBytes is the rough size of a raster tile, used to estimate
+	// how large a download will be.
+	EstimatedTileBytes = 20 * 1024
+)
+
+var (
+	ErrTooManyTiles  = errors.New("region has too many tiles")
+	ErrInvalidBounds = errors.New("invalid bounds")
+	ErrInvalidZoom   = errors.New("zoom levels out of range")
+)
+
+// TileWriter is somewhere downloaded tiles can be kept, such as a DiskCache
+// or an MBTiles file.
+type TileWriter interface {
+	Has(coord TileCoord) bool
+	Store(raw *RawTile) error
+}
+
+// Region is an area of the map over a range of zoom levels
+type Region struct {
+	Bounds  Bounds
+	MinZoom uint32
+	MaxZoom uint32
+}
+
+type DownloadProgress struct {
+	Total      int
+	Downloaded int
+	Skipped    int
+	Failed     int
+	Bytes      int64
+}
+
+func (p DownloadProgress) Done() int {
+	return p.Downloaded + p.Skipped + p.Failed
+}
+
+// Downloader fetches every tile in a region from a source into a writer. Regions
+// from the OpenStreetMap tile servers are never allowed more than
+// DefaultMaxDownloadTiles, whatever MaxTiles is, nor fetched over more than
+// DefaultDownloadConcurrency connections, whatever Concurrency is.
+type Downloader struct {
+	Source      TileSource
+	Writer      TileWriter
+	Concurrency int
+	MaxTiles    int
+	// Progress is called after each tile is handled
+	Progress func(DownloadProgress)
+}
+
+// lonLatToTile finds the tile containing the WGS84 position at zoom z
+func lonLatToTile(lon, lat float64, z uint32) (uint32, uint32) {
+	n := float64(uint64(1) << z)
+	x, y := projection.LatLonToTile(lat, lon, z)
+
+	clamp := func(v float64) uint32 {
+		return uint32(math.Max(0, math.Min(n-1, math.Floor(v))))
+	}
+
+	return clamp(x), clamp(y)
+}
+
+func (r Region) tileRange(z uint32) (minX, minY, maxX, maxY uint32) {
+	minX, minY = lonLatToTile(r.Bounds.MinLon, r.Bounds.MaxLat, z)
+	maxX, maxY = lonLatToTile(r.Bounds.MaxLon, r.Bounds.MinLat, z)
+
+	return minX, minY, maxX, maxY
+}
+
+// TileCount is how many tiles cover the region over all of its zoom levels
+func (r Region) TileCount() uint64 {
+	count := uint64(0)
+	for z := uint64(r.MinZoom); z <= uint64(r.MaxZoom); z++ {
+		minX, minY, maxX, maxY := r.tileRange(uint32(z))
+		if maxX < minX || maxY < minY {
+			continue
+		}
+		count += (uint64(maxX) - uint64(minX) + 1) * (uint64(maxY) - uint64(minY) + 1)
+	}
+
+	return count
+}
+
+// ForEachTile calls f for every tile in the region, lowest zoom first,
+// stopping early if f returns false.
+func (r Region) ForEachTile(f func(TileCoord) bool) {
+	// Counted in 64 bits so that the last of each range does not wrap
+	for z := uint64(r.MinZoom); z <= uint64(r.MaxZoom); z++ {
+		minX, minY, maxX, maxY := r.tileRange(uint32(z))
+		for x := uint64(minX); x <= uint64(maxX); x++ {
+			for y := uint64(minY); y <= uint64(maxY); y++ {
+				if !f(TileCoord{X: uint32(x), Y: uint32(y), Z: uint32(z)}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *Downloader) isOpenStreetMap() bool {
+	ds, ok := d.Source.(*TileDatasource)
+	return ok && ds.IsOpenStreetMap()
+}
+
+func (d *Downloader) maxTiles() int {
+	if d.MaxTiles <= 0 {
+		return DefaultMaxDownloadTiles
+	}
+	if d.isOpenStreetMap() && d.MaxTiles > DefaultMaxDownloadTiles {
+		return DefaultMaxDownloadTiles
+	}
+	return d.MaxTiles
+}
+
+func (d *Downloader) concurrency() int {
+	if d.Concurrency <= 0 {
+		return DefaultDownloadConcurrency
+	}
+	if d.isOpenStreetMap() && d.Concurrency > DefaultDownloadConcurrency {
+		return DefaultDownloadConcurrency
+	}
+	return d.Concurrency
+}
+
+func (d *Downloader) check(region Region) error {
+	if region.MinZoom > region.MaxZoom {
+		return fmt.Errorf("invalid zoom range %d-%d", region.MinZoom, region.MaxZoom)
+	}
+	info := d.Source.Info().WithDefaults()
+	if region.MinZoom < info.MinZoom || region.MaxZoom > info.MaxZoom {
+		return fmt.Errorf("%w: %d-%d, the source has zoom levels %d-%d", ErrInvalidZoom, region.MinZoom, region.MaxZoom, info.MinZoom, info.MaxZoom)
+	}
+	// Regions crossing the antimeridian would need splitting in two
+	if err := region.Bounds.Validate(); err != nil {
+		return err
+	}
+	if count := region.TileCount(); count > uint64(d.maxTiles()) {
+		return fmt.Errorf("%w: %d tiles, at most %d may be downloaded", ErrTooManyTiles, count, d.maxTiles())
+	}
+
+	return nil
+}
+
+// Estimate returns how many tiles in the region still need downloading and
+// roughly how many bytes they will take up.
+func (d *Downloader) Estimate(region Region) (int, int64, error) {
+	if err := d.check(region); err != nil {
+		return 0, 0, err
+	}
+
+	missing := 0
+	region.ForEachTile(func(coord TileCoord) bool {
+		if d.Writer == nil || !d.Writer.Has(coord) {
+			missing++
+		}
+		return true
+	})
+
+	return missing, int64(missing) * EstimatedTileBytes, nil
+}
+
+// Download fetches all tiles of the region which the writer does not already
+// have, so an interrupted download can be resumed by running it again.
+func (d *Downloader) Download(ctx context.Context, region Region) (DownloadProgress, error) {
+	progress := DownloadProgress{}
+	if err := d.check(region); err != nil {
+		return progress, err
+	}
+	// Checked to be no more than MaxTiles
+	progress.Total = int(region.TileCount())
+
+	concurrency := d.concurrency()
+
+	var mu sync.Mutex
+	var firstErr error
+	report := func(update func(*DownloadProgress)) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		update(&progress)
+		if d.Progress != nil {
+			d.Progress(progress)
+		}
+	}
+
+	coords := make(chan TileCoord)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for coord := range coords {
+				raw, err := fetchRawTile(ctx, d.Source, coord, nil)
+				if err == nil {
+					// Writers are not expected to be safe for concurrent use
+					mu.Lock()
+					err = d.Writer.Store(raw)
+					mu.Unlock()
+				}
+				if err != nil {
+					report(func(p *DownloadProgress) {
+						p.Failed++
+						if firstErr == nil && ctx.Err() == nil {
+							firstErr = fmt.Errorf("tile %v: %w", coord, err)
+						}
+					})
+					continue
+				}
+
+				report(func(p *DownloadProgress) {
+					p.Downloaded++
+					p.Bytes += int64(len(raw.Data))
+				})
+			}
+		}()
+	}
+
+	region.ForEachTile(func(coord TileCoord) bool {
+		if d.Writer.Has(coord) {
+			report(func(p *DownloadProgress) {
+				p.Skipped++
+			})
+			return true
+		}
+
+		select {
+		case coords <- coord:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(coords)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return progress, ctx.Err()
+	}
+
+	return progress, firstErr
+}
@@ -0,0 +1,162 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+type countingSource struct {
+	fetches int32
+}
+
+func (s *countingSource) Tile(ctx context.Context, coord TileCoord) (*PngTile, error) {
+	atomic.AddInt32(&s.fetches, 1)
+	return &PngTile{
+		Tile:  coord,
+		Image: image.NewRGBA(image.Rect(0, 0, 256, 256)),
+	}, nil
+}
+
+func (s *countingSource) Info() SourceInfo {
+	return SourceInfo{}.WithDefaults()
+}
+
+func TestRegion_TileCount(t *testing.T) {
+	world := Region{
+		Bounds:  Bounds{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90},
+		MinZoom: 0,
+		MaxZoom: 3,
+	}
+	if count := world.TileCount(); count != 1+4+16+64 {
+		t.Errorf("unexpected tile count for the world %d", count)
+	}
+
+	// Wellington, which spans a 2x2 block of tiles at zoom 10
+	wellington := Region{
+		Bounds:  Bounds{MinLon: 174.6141733, MinLat: -41.3523814, MaxLon: 174.9787463, MaxLat: -41.2028753},
+		MinZoom: 10,
+		MaxZoom: 10,
+	}
+	first := TileCoord{}
+	wellington.ForEachTile(func(coord TileCoord) bool {
+		first = coord
+		return false
+	})
+	if first.X != 1008 || first.Y != 640 || wellington.TileCount() != 4 {
+		t.Errorf("unexpected tiles for region, first %v of %d", first, wellington.TileCount())
+	}
+}
+
+func TestDownloader_Limits(t *testing.T) {
+	region := Region{
+		Bounds:  Bounds{MinLon: -180, MinLat: -85, MaxLon: 180, MaxLat: 85},
+		MinZoom: 0,
+		MaxZoom: 7,
+	}
+
+	// Other servers may allow larger downloads, OpenStreetMap never does
+	ds, err := NewTileDatasource("https://tiles.example.com/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	custom := &Downloader{Source: ds, MaxTiles: 100000}
+	if _, _, err := custom.Estimate(region); err != nil {
+		t.Errorf("expected the limit raised, got %s", err)
+	}
+	osmTemplate, err := NewTileDatasource("https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", "a", "b", "c")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, source := range []*TileDatasource{DefaultTileDatasource, osmTemplate} {
+		osm := &Downloader{Source: source, MaxTiles: 100000, Concurrency: 8}
+		if _, _, err := osm.Estimate(region); !errors.Is(err, ErrTooManyTiles) {
+			t.Errorf("%s%s: expected too many tiles, got %v", source.BaseURL, source.URLTemplate, err)
+		}
+		if osm.concurrency() != DefaultDownloadConcurrency {
+			t.Errorf("%s%s: expected at most %d connections, got %d", source.BaseURL, source.URLTemplate, DefaultDownloadConcurrency, osm.concurrency())
+		}
+	}
+	custom.Concurrency = 8
+	if custom.concurrency() != 8 {
+		t.Errorf("expected the concurrency raised, got %d", custom.concurrency())
+	}
+
+	// Reversed bounds are invalid rather than huge
+	for _, bounds := range []Bounds{
+		{MinLon: 174.9, MinLat: -41.3, MaxLon: 174.6, MaxLat: -41.2},
+		{MinLon: 174.6, MinLat: -41.2, MaxLon: 174.9, MaxLat: -41.3},
+		{MinLon: 179, MinLat: -20, MaxLon: -179, MaxLat: -10},
+	} {
+		_, _, err := custom.Estimate(Region{Bounds: bounds, MinZoom: 10, MaxZoom: 10})
+		if !errors.Is(err, ErrInvalidBounds) {
+			t.Errorf("%v: expected invalid bounds, got %v", bounds, err)
+		}
+	}
+
+	// Zoom levels past those of the source would wrap the tile ranges
+	for _, zoom := range [][2]uint32{{32, 32}, {0, 40}, {0, math.MaxUint32}} {
+		_, _, err := custom.Estimate(Region{Bounds: region.Bounds, MinZoom: zoom[0], MaxZoom: zoom[1]})
+		if !errors.Is(err, ErrInvalidZoom) {
+			t.Errorf("zoom %d-%d: expected invalid zoom, got %v", zoom[0], zoom[1], err)
+		}
+	}
+}
+
+func TestDownloader_Download(t *testing.T) {
+	source := &countingSource{}
+	writer, err := CreateMBTiles(filepath.Join(t.TempDir(), "region.mbtiles"), "test", source.Info())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer writer.Close()
+
+	region := Region{
+		Bounds:  Bounds{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90},
+		MinZoom: 0,
+		MaxZoom: 2,
+	}
+	reports := 0
+	downloader := &Downloader{
+		Source:   source,
+		Writer:   writer,
+		MaxTiles: 21,
+		Progress: func(DownloadProgress) {
+			reports++
+		},
+	}
+
+	missing, size, err := downloader.Estimate(region)
+	if err != nil || missing != 21 || size != 21*EstimatedTileBytes {
+		t.Errorf("unexpected estimate %d tiles, %d bytes: %v", missing, size, err)
+	}
+
+	progress, err := downloader.Download(context.Background(), region)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if progress.Downloaded != 21 || source.fetches != 21 || reports != 21 {
+		t.Errorf("unexpected progress %+v after %d fetches", progress, source.fetches)
+	}
+	if !writer.Has(TileCoord{X: 3, Y: 3, Z: 2}) {
+		t.Errorf("tile missing from the written file")
+	}
+
+	// Running again resumes, so nothing should be fetched
+	progress, err = downloader.Download(context.Background(), region)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if progress.Skipped != 21 || source.fetches != 21 {
+		t.Errorf("download did not resume, %+v", progress)
+	}
+
+	region.MaxZoom = 3
+	if _, err := downloader.Download(context.Background(), region); !errors.Is(err, ErrTooManyTiles) {
+		t.Errorf("expected tile cap to be enforced, got %v", err)
+	}
+}
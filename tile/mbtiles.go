@@ -0,0 +1,254 @@
+package tile
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrTileNotFound = errors.New("tile not found")
+
+var _ RawTileSource = (*MBTiles)(nil)
+
+// MBTiles reads raster tiles from an MBTiles SQLite database, see
+// https://github.com/mapbox/mbtiles-spec
+type MBTiles struct {
+	Path   string
+	Name   string
+	Format string
+	db     *sql.DB
+	info   SourceInfo
+}
+
+func OpenMBTiles(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	mbtiles := &MBTiles{
+		Path: path,
+		db:   db,
+	}
+	if err := mbtiles.readMetadata(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return mbtiles, nil
+}
+
+func (m *MBTiles) readMetadata() error {
+	rows, err := m.db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	metadata := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		metadata[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	m.Name = metadata["name"]
+	m.Format = metadata["format"]
+	m.info.Attribution = metadata["attribution"]
+	if bounds, ok := metadata["bounds"]; ok {
+		m.info.Bounds, err = ParseBounds(bounds)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The zoom range is optional metadata, fall back to what is in the tiles
+	minZoom, hasMin := metadata["minzoom"]
+	maxZoom, hasMax := metadata["maxzoom"]
+	if !hasMin || !hasMax {
+		var min, max sql.NullInt64
+		row := m.db.QueryRow("SELECT MIN(zoom_level), MAX(zoom_level) FROM tiles")
+		if err := row.Scan(&min, &max); err != nil {
+			return err
+		}
+		minZoom = strconv.FormatInt(min.Int64, 10)
+		maxZoom = strconv.FormatInt(max.Int64, 10)
+	}
+	min, err := strconv.ParseUint(minZoom, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid minzoom: %w", err)
+	}
+	max, err := strconv.ParseUint(maxZoom, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid maxzoom: %w", err)
+	}
+	m.info.MinZoom = uint32(min)
+	m.info.MaxZoom = uint32(max)
+	m.info.TileSize = DefaultTileSize
+
+	return nil
+}
+
+func (m *MBTiles) Info() SourceInfo {
+	return m.info
+}
+
+func (m *MBTiles) RawTile(ctx context.Context, coord TileCoord, _ *RawTile) (*RawTile, error) {
+	if !m.info.Contains(coord) {
+		return nil, ErrTileNotFound
+	}
+
+	// MBTiles uses the TMS tiling scheme, where y counts up from the south
+	tmsY := (uint32(1) << coord.Z) - 1 - coord.Y
+
+	var data []byte
+	row := m.db.QueryRowContext(ctx,
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		coord.Z, coord.X, tmsY)
+	if err := row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTileNotFound
+		}
+		return nil, err
+	}
+
+	return &RawTile{
+		Tile: coord,
+		Data: data,
+	}, nil
+}
+
+func (m *MBTiles) Tile(ctx context.Context, coord TileCoord) (*PngTile, error) {
+	raw, err := m.RawTile(ctx, coord, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPngTile(coord.X, coord.Y, coord.Z, raw.Data)
+}
+
+// CreateMBTiles opens the MBTiles file at path for writing, creating it if it
+// does not already exist. The zoom range of an existing file is widened to
+// include that of info, so downloads can add to it.
+func CreateMBTiles(path string, name string, info SourceInfo) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=rwc")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so serialise rather than hit lock errors
+	db.SetMaxOpenConns(1)
+
+	mbtiles := &MBTiles{
+		Path: path,
+		db:   db,
+	}
+	if err := mbtiles.createSchema(name, info); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := mbtiles.readMetadata(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return mbtiles, nil
+}
+
+func (m *MBTiles) createSchema(name string, info SourceInfo) error {
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS metadata (name text, value text)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS metadata_name ON metadata (name)",
+		"CREATE TABLE IF NOT EXISTS tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS tile_index ON tiles (zoom_level, tile_column, tile_row)",
+	}
+	for _, statement := range statements {
+		if _, err := m.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	metadata := map[string]string{
+		"name":        name,
+		"type":        "baselayer",
+		"version":     "1",
+		"attribution": info.Attribution,
+	}
+	if !info.Bounds.IsZero() {
+		metadata["bounds"] = info.Bounds.String()
+	}
+	for key, value := range metadata {
+		if _, err := m.db.Exec("INSERT OR IGNORE INTO metadata VALUES (?, ?)", key, value); err != nil {
+			return err
+		}
+	}
+
+	zoomRange := []struct {
+		key   string
+		value uint32
+		query string
+	}{
+		{"minzoom", info.MinZoom, "UPDATE metadata SET value = MIN(CAST(value AS integer), ?) WHERE name = ?"},
+		{"maxzoom", info.MaxZoom, "UPDATE metadata SET value = MAX(CAST(value AS integer), ?) WHERE name = ?"},
+	}
+	for _, zoom := range zoomRange {
+		if _, err := m.db.Exec(zoom.query, zoom.value, zoom.key); err != nil {
+			return err
+		}
+		if _, err := m.db.Exec("INSERT OR IGNORE INTO metadata VALUES (?, ?)", zoom.key, zoom.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MBTiles) Has(coord TileCoord) bool {
+	tmsY := (uint32(1) << coord.Z) - 1 - coord.Y
+
+	var exists int
+	row := m.db.QueryRow(
+		"SELECT 1 FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		coord.Z, coord.X, tmsY)
+
+	return row.Scan(&exists) == nil
+}
+
+func (m *MBTiles) Store(raw *RawTile) error {
+	coord := raw.Tile
+	tmsY := (uint32(1) << coord.Z) - 1 - coord.Y
+
+	_, err := m.db.Exec(
+		"INSERT OR REPLACE INTO tiles VALUES (?, ?, ?, ?)",
+		coord.Z, coord.X, tmsY, raw.Data)
+	if err != nil {
+		return err
+	}
+
+	// The format is only known once we have seen a tile
+	if m.Format == "" {
+		format := "png"
+		if http.DetectContentType(raw.Data) == "image/jpeg" {
+			format = "jpg"
+		}
+		if _, err := m.db.Exec("INSERT OR REPLACE INTO metadata VALUES ('format', ?)", format); err != nil {
+			return err
+		}
+		m.Format = format
+	}
+
+	return nil
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
@@ -0,0 +1,85 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestMBTiles(t *testing.T, path string, metadata map[string]string, tiles map[TileCoord][]byte) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer db.Close()
+
+	statements := []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	for name, value := range metadata {
+		if _, err := db.Exec("INSERT INTO metadata VALUES (?, ?)", name, value); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	for coord, data := range tiles {
+		tmsY := (uint32(1) << coord.Z) - 1 - coord.Y
+		if _, err := db.Exec("INSERT INTO tiles VALUES (?, ?, ?, ?)", coord.Z, coord.X, tmsY, data); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+}
+
+func TestMBTiles_Tile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256))); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+	writeTestMBTiles(t, path, map[string]string{
+		"name":        "test",
+		"format":      "png",
+		"bounds":      "-180,-85.05,180,85.05",
+		"attribution": "test data",
+	}, map[TileCoord][]byte{
+		{X: 1, Y: 0, Z: 2}: buf.Bytes(),
+		{X: 0, Y: 0, Z: 0}: buf.Bytes(),
+	})
+
+	mbtiles, err := OpenMBTiles(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer mbtiles.Close()
+
+	info := mbtiles.Info()
+	if info.MinZoom != 0 || info.MaxZoom != 2 {
+		t.Errorf("zoom range not read from tiles: %d-%d", info.MinZoom, info.MaxZoom)
+	}
+	if info.Attribution != "test data" || info.Bounds.MaxLat != 85.05 {
+		t.Errorf("metadata not read: %+v", info)
+	}
+
+	tile, err := mbtiles.Tile(context.Background(), TileCoord{X: 1, Y: 0, Z: 2})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if tile.Image == nil {
+		t.Errorf("tile image missing")
+	}
+
+	// The y axis is flipped, so this would be the tile above if it wasn't
+	if _, err := mbtiles.Tile(context.Background(), TileCoord{X: 1, Y: 3, Z: 2}); err != ErrTileNotFound {
+		t.Errorf("expected missing tile, got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package tile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	pmtilesHeaderLength = 127
+	pmtilesMaxDepth     = 4
+	pmtilesLeafCache    = 64
+)
+
+// Compression and tile types from the PMTiles v3 header
+const (
+	pmtilesCompressionUnknown = 0
+	pmtilesCompressionNone    = 1
+	pmtilesCompressionGzip    = 2
+
+	pmtilesTypePng  = 2
+	pmtilesTypeJpeg = 3
+)
+
+var _ RawTileSource = (*PMTiles)(nil)
+
+type pmtilesHeader struct {
+	RootOffset          uint64
+	RootLength          uint64
+	MetadataOffset      uint64
+	MetadataLength      uint64
+	LeafOffset          uint64
+	LeafLength          uint64
+	TileDataOffset      uint64
+	TileDataLength      uint64
+	InternalCompression uint8
+	TileCompression     uint8
+	TileType            uint8
+	MinZoom             uint8
+	MaxZoom             uint8
+	MinLonE7            int32
+	MinLatE7            int32
+	MaxLonE7            int32
+	MaxLatE7            int32
+}
+
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// PMTiles reads raster tiles from a local PMTiles v3 archive, see
+// https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+type PMTiles struct {
+	Path   string
+	file   *os.File
+	header pmtilesHeader
+	info   SourceInfo
+	root   []pmtilesEntry
+	leafMu sync.Mutex
+	leaves map[uint64][]pmtilesEntry
+}
+
+func OpenPMTiles(path string) (*PMTiles, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pmtiles := &PMTiles{
+		Path:   path,
+		file:   file,
+		leaves: map[uint64][]pmtilesEntry{},
+	}
+	if err := pmtiles.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return pmtiles, nil
+}
+
+func (p *PMTiles) readHeader() error {
+	buf := make([]byte, pmtilesHeaderLength)
+	if _, err := p.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if string(buf[0:7]) != "PMTiles" {
+		return errors.New("not a pmtiles archive")
+	}
+	if buf[7] != 3 {
+		return fmt.Errorf("unsupported pmtiles version %d", buf[7])
+	}
+
+	h := &p.header
+	h.RootOffset = binary.LittleEndian.Uint64(buf[8:16])
+	h.RootLength = binary.LittleEndian.Uint64(buf[16:24])
+	h.MetadataOffset = binary.LittleEndian.Uint64(buf[24:32])
+	h.MetadataLength = binary.LittleEndian.Uint64(buf[32:40])
+	h.LeafOffset = binary.LittleEndian.Uint64(buf[40:48])
+	h.LeafLength = binary.LittleEndian.Uint64(buf[48:56])
+	h.TileDataOffset = binary.LittleEndian.Uint64(buf[56:64])
+	h.TileDataLength = binary.LittleEndian.Uint64(buf[64:72])
+	h.InternalCompression = buf[97]
+	h.TileCompression = buf[98]
+	h.TileType = buf[99]
+	h.MinZoom = buf[100]
+	h.MaxZoom = buf[101]
+	h.MinLonE7 = int32(binary.LittleEndian.Uint32(buf[102:106]))
+	h.MinLatE7 = int32(binary.LittleEndian.Uint32(buf[106:110]))
+	h.MaxLonE7 = int32(binary.LittleEndian.Uint32(buf[110:114]))
+	h.MaxLatE7 = int32(binary.LittleEndian.Uint32(buf[114:118]))
+
+	if h.TileType != pmtilesTypePng && h.TileType != pmtilesTypeJpeg {
+		return fmt.Errorf("unsupported pmtiles tile type %d, only png and jpeg are supported", h.TileType)
+	}
+
+	p.info = SourceInfo{
+		MinZoom:  uint32(h.MinZoom),
+		MaxZoom:  uint32(h.MaxZoom),
+		TileSize: DefaultTileSize,
+		Bounds: Bounds{
+			MinLon: float64(h.MinLonE7) / 1e7,
+			MinLat: float64(h.MinLatE7) / 1e7,
+			MaxLon: float64(h.MaxLonE7) / 1e7,
+			MaxLat: float64(h.MaxLatE7) / 1e7,
+		},
+	}
+
+	root, err := p.readDirectory(h.RootOffset, h.RootLength)
+	if err != nil {
+		return fmt.Errorf("root directory: %w", err)
+	}
+	p.root = root
+
+	if h.MetadataLength > 0 {
+		metadata, err := p.read(h.MetadataOffset, h.MetadataLength, h.InternalCompression)
+		if err != nil {
+			return fmt.Errorf("metadata: %w", err)
+		}
+		fields := struct {
+			Attribution string `json:"attribution"`
+		}{}
+		if err := json.Unmarshal(metadata, &fields); err == nil {
+			p.info.Attribution = fields.Attribution
+		}
+	}
+
+	return nil
+}
+
+// read returns a section of the archive, decompressing it if needed
+func (p *PMTiles) read(offset, length uint64, compression uint8) ([]byte, error) {
+	buf := make([]byte, length)
+	if _, err := p.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case pmtilesCompressionNone, pmtilesCompressionUnknown:
+		return buf, nil
+	case pmtilesCompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return ioutil.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unsupported pmtiles compression %d", compression)
+	}
+}
+
+func (p *PMTiles) readDirectory(offset, length uint64) ([]pmtilesEntry, error) {
+	data, err := p.read(offset, length, p.header.InternalCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePMTilesDirectory(data)
+}
+
+// decodePMTilesDirectory decodes the columnar, varint encoded directory
+func decodePMTilesDirectory(data []byte) ([]pmtilesEntry, error) {
+	reader := bytes.NewReader(data)
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(data)) {
+		return nil, errors.New("invalid pmtiles directory length")
+	}
+	entries := make([]pmtilesEntry, count)
+
+	var tileID uint64
+	for i := range entries {
+		delta, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		tileID += delta
+		entries[i].TileID = tileID
+	}
+	for i := range entries {
+		runLength, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].RunLength = uint32(runLength)
+	}
+	for i := range entries {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Length = uint32(length)
+	}
+	for i := range entries {
+		offset, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		// Zero means the data directly follows that of the previous entry
+		switch {
+		case offset > 0:
+			entries[i].Offset = offset - 1
+		case i > 0:
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		default:
+			return nil, errors.New("invalid pmtiles directory offset")
+		}
+	}
+
+	return entries, nil
+}
+
+// findPMTilesEntry finds the entry holding the tile, either its run of tile
+// data or the leaf directory which covers it.
+func findPMTilesEntry(entries []pmtilesEntry, tileID uint64) (pmtilesEntry, bool) {
+	m := 0
+	n := len(entries) - 1
+	for m <= n {
+		k := (m + n) >> 1
+		switch {
+		case tileID > entries[k].TileID:
+			m = k + 1
+		case tileID < entries[k].TileID:
+			n = k - 1
+		default:
+			return entries[k], true
+		}
+	}
+
+	if n >= 0 {
+		if entries[n].RunLength == 0 {
+			return entries[n], true
+		}
+		if tileID-entries[n].TileID < uint64(entries[n].RunLength) {
+			return entries[n], true
+		}
+	}
+
+	return pmtilesEntry{}, false
+}
+
+// pmtilesTileID is the position of the tile along the Hilbert curves which
+// order the tiles of each zoom level, after all tiles of the lower zooms.
+func pmtilesTileID(coord TileCoord) uint64 {
+	if coord.Z == 0 {
+		return 0
+	}
+
+	id := ((uint64(1) << (coord.Z * 2)) - 1) / 3
+	x, y := coord.X, coord.Y
+	for s := uint32(1) << (coord.Z - 1); s > 0; s >>= 1 {
+		rx := s & x
+		ry := s & y
+		id += uint64((3*rx)^ry) * uint64(s)
+
+		// Rotate the quadrant
+		if ry == 0 {
+			if rx != 0 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+
+	return id
+}
+
+func (p *PMTiles) leaf(offset, length uint64) ([]pmtilesEntry, error) {
+	p.leafMu.Lock()
+	defer p.leafMu.Unlock()
+
+	if entries, exists := p.leaves[offset]; exists {
+		return entries, nil
+	}
+	entries, err := p.readDirectory(p.header.LeafOffset+offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.leaves) >= pmtilesLeafCache {
+		p.leaves = map[uint64][]pmtilesEntry{}
+	}
+	p.leaves[offset] = entries
+
+	return entries, nil
+}
+
+func (p *PMTiles) Info() SourceInfo {
+	return p.info
+}
+
+func (p *PMTiles) RawTile(ctx context.Context, coord TileCoord, _ *RawTile) (*RawTile, error) {
+	if !p.info.Contains(coord) {
+		return nil, ErrTileNotFound
+	}
+	tileID := pmtilesTileID(coord)
+
+	entries := p.root
+	for depth := 0; depth < pmtilesMaxDepth; depth++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		entry, found := findPMTilesEntry(entries, tileID)
+		if !found {
+			return nil, ErrTileNotFound
+		}
+		if entry.RunLength > 0 {
+			data, err := p.read(p.header.TileDataOffset+entry.Offset, uint64(entry.Length), p.header.TileCompression)
+			if err != nil {
+				return nil, err
+			}
+
+			return &RawTile{
+				Tile: coord,
+				Data: data,
+			}, nil
+		}
+
+		leaf, err := p.leaf(entry.Offset, uint64(entry.Length))
+		if err != nil {
+			return nil, err
+		}
+		entries = leaf
+	}
+
+	return nil, ErrTileNotFound
+}
+
+func (p *PMTiles) Tile(ctx context.Context, coord TileCoord) (*PngTile, error) {
+	raw, err := p.RawTile(ctx, coord, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPngTile(coord.X, coord.Y, coord.Z, raw.Data)
+}
+
+func (p *PMTiles) Close() error {
+	return p.file.Close()
+}
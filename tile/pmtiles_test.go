@@ -0,0 +1,160 @@
+package tile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func gzipTestData(t *testing.T, data []byte) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return compressed.Bytes()
+}
+
+func encodeTestPMTilesDirectory(t *testing.T, entries []pmtilesEntry) []byte {
+	buf := []byte{}
+	varint := make([]byte, binary.MaxVarintLen64)
+	appendUvarint := func(v uint64) {
+		n := binary.PutUvarint(varint, v)
+		buf = append(buf, varint[:n]...)
+	}
+
+	appendUvarint(uint64(len(entries)))
+	var last uint64
+	for _, e := range entries {
+		appendUvarint(e.TileID - last)
+		last = e.TileID
+	}
+	for _, e := range entries {
+		appendUvarint(uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		appendUvarint(uint64(e.Length))
+	}
+	for _, e := range entries {
+		appendUvarint(e.Offset + 1)
+	}
+
+	return gzipTestData(t, buf)
+}
+
+// writeTestPMTiles writes an archive with all tiles in a single leaf
+// directory, with every tile sharing the same data.
+func writeTestPMTiles(t *testing.T, path string, coords []TileCoord, data []byte) {
+	leafEntries := []pmtilesEntry{}
+	for _, coord := range coords {
+		leafEntries = append(leafEntries, pmtilesEntry{
+			TileID:    pmtilesTileID(coord),
+			Offset:    0,
+			Length:    uint32(len(data)),
+			RunLength: 1,
+		})
+	}
+	leaf := encodeTestPMTilesDirectory(t, leafEntries)
+	root := encodeTestPMTilesDirectory(t, []pmtilesEntry{{
+		TileID: leafEntries[0].TileID,
+		Offset: 0,
+		Length: uint32(len(leaf)),
+	}})
+	metadata := gzipTestData(t, []byte(`{"attribution":"test data"}`))
+
+	header := make([]byte, pmtilesHeaderLength)
+	copy(header, "PMTiles")
+	header[7] = 3
+	header[97] = pmtilesCompressionGzip
+	header[98] = pmtilesCompressionNone
+	header[99] = pmtilesTypePng
+	header[100] = 0
+	header[101] = 4
+
+	// Root directory, metadata, leaf directories then tile data
+	sections := [][]byte{root, metadata, leaf, data}
+	offset := uint64(pmtilesHeaderLength)
+	for i, section := range sections {
+		binary.LittleEndian.PutUint64(header[8+i*16:], offset)
+		binary.LittleEndian.PutUint64(header[16+i*16:], uint64(len(section)))
+		offset += uint64(len(section))
+	}
+	archive := header
+	for _, section := range sections {
+		archive = append(archive, section...)
+	}
+	if err := ioutil.WriteFile(path, archive, 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func TestPMTiles_TileID(t *testing.T) {
+	cases := map[TileCoord]uint64{
+		{X: 0, Y: 0, Z: 0}:  0,
+		{X: 0, Y: 0, Z: 1}:  1,
+		{X: 0, Y: 1, Z: 1}:  2,
+		{X: 1, Y: 1, Z: 1}:  3,
+		{X: 1, Y: 0, Z: 1}:  4,
+		{X: 0, Y: 0, Z: 2}:  5,
+		{X: 3, Y: 0, Z: 2}:  20,
+		{X: 0, Y: 0, Z: 12}: 5592405,
+	}
+	for coord, expected := range cases {
+		if id := pmtilesTileID(coord); id != expected {
+			t.Errorf("tile id of %v: expected %d, got %d", coord, expected, id)
+		}
+	}
+}
+
+func TestPMTiles_Tile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256))); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pmtiles")
+	coords := []TileCoord{{X: 0, Y: 0, Z: 0}, {X: 1, Y: 1, Z: 1}, {X: 2, Y: 1, Z: 2}}
+	writeTestPMTiles(t, path, coords, buf.Bytes())
+
+	pmtiles, err := OpenPMTiles(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer pmtiles.Close()
+
+	info := pmtiles.Info()
+	if info.MaxZoom != 4 || info.Attribution != "test data" {
+		t.Errorf("unexpected source info %+v", info)
+	}
+
+	for _, coord := range coords {
+		tile, err := pmtiles.Tile(context.Background(), coord)
+		if err != nil {
+			t.Fatalf("%v: %s", coord, err)
+		}
+		if tile.Image.Bounds().Dx() != 256 {
+			t.Errorf("%v: unexpected tile image", coord)
+		}
+	}
+
+	if _, err := pmtiles.Tile(context.Background(), TileCoord{X: 1, Y: 0, Z: 1}); err != ErrTileNotFound {
+		t.Errorf("expected missing tile, got %v", err)
+	}
+}
+
+func TestOpenSource_MissingArchive(t *testing.T) {
+	source, err := OpenSource(filepath.Join(t.TempDir(), "missing.pmtiles"))
+	if err == nil || source != nil {
+		t.Errorf("expected no source, got %v: %v", source, err)
+	}
+}
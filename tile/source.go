@@ -0,0 +1,168 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTileSize = 256
+	DefaultMinZoom  = 0
+	DefaultMaxZoom  = 19
+)
+
+// TileSource is anything that can produce map tiles by their coordinate, such
+// as a remote tile server, an on-disk cache, an offline archive or a test fake.
+type TileSource interface {
+	// Tile fetches the tile at coord, returning early if ctx is cancelled.
+	Tile(ctx context.Context, coord TileCoord) (*PngTile, error)
+	// Info describes the zoom range, tile size and attribution of the source.
+	Info() SourceInfo
+}
+
+type SourceInfo struct {
+	MinZoom     uint32
+	MaxZoom     uint32
+	TileSize    uint32
+	Attribution string
+	// Bounds of the area covered, zero when the source covers the whole world
+	Bounds Bounds
+}
+
+// Bounds is a WGS84 bounding box in degrees
+type Bounds struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+func (b Bounds) IsZero() bool {
+	return b == Bounds{}
+}
+
+// Validate checks that the bounds are the right way round, which they are
+// not when they cross the antimeridian
+func (b Bounds) Validate() error {
+	if b.MinLat >= b.MaxLat {
+		return fmt.Errorf("%w: min latitude %f is not below max latitude %f", ErrInvalidBounds, b.MinLat, b.MaxLat)
+	}
+	if b.MinLon >= b.MaxLon {
+		return fmt.Errorf("%w: min longitude %f is not below max longitude %f", ErrInvalidBounds, b.MinLon, b.MaxLon)
+	}
+
+	return nil
+}
+
+// String formats the bounds as "left,bottom,right,top", as used by MBTiles
+func (b Bounds) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+// ParseBounds reads bounds in the "left,bottom,right,top" form used by MBTiles
+func ParseBounds(s string) (Bounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Bounds{}, fmt.Errorf("invalid bounds %q", s)
+	}
+
+	values := [4]float64{}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Bounds{}, fmt.Errorf("invalid bounds %q: %w", s, err)
+		}
+		values[i] = v
+	}
+
+	return Bounds{
+		MinLon: values[0],
+		MinLat: values[1],
+		MaxLon: values[2],
+		MaxLat: values[3],
+	}, nil
+}
+
+// WithDefaults returns a copy of the info with any unset fields filled in
+func (info SourceInfo) WithDefaults() SourceInfo {
+	if info.TileSize == 0 {
+		info.TileSize = DefaultTileSize
+	}
+	if info.MaxZoom == 0 {
+		info.MaxZoom = DefaultMaxZoom
+	}
+	if info.MinZoom > info.MaxZoom {
+		info.MinZoom = info.MaxZoom
+	}
+
+	return info
+}
+
+// Contains reports whether the coordinate is within the zoom range and tile
+// bounds described by the info.
+func (info SourceInfo) Contains(coord TileCoord) bool {
+	if coord.Z < info.MinZoom || coord.Z > info.MaxZoom {
+		return false
+	}
+	n := uint32(1) << coord.Z
+
+	return coord.X < n && coord.Y < n
+}
+
+// RawTile is an encoded tile as it was fetched, along with the HTTP validators
+// used to decide when it needs to be fetched again.
+type RawTile struct {
+	Tile         TileCoord
+	Data         []byte
+	ETag         string
+	LastModified string
+	// Expires is zero when the source gave no indication of freshness
+	Expires time.Time
+	// NotModified is set when a conditional fetch found prev still current
+	NotModified bool
+}
+
+// RawTileSource is a TileSource which can also hand out the encoded tile data,
+// used by caches to store tiles without re-encoding them.
+type RawTileSource interface {
+	TileSource
+	// RawTile fetches the encoded tile, revalidating prev when it is given.
+	RawTile(ctx context.Context, coord TileCoord, prev *RawTile) (*RawTile, error)
+}
+
+// OpenSource opens the tile source at location, which is either a tile server
+// URL template, the path of an offline tile archive, or empty for the
+// DefaultTileDatasource. The source is nil if it cannot be opened.
+func OpenSource(location string) (TileSource, error) {
+	switch {
+	case location == "":
+		return DefaultTileDatasource, nil
+	case strings.Contains(location, "://"):
+		ds, err := NewTileDatasource(location)
+		if err != nil {
+			return nil, err
+		}
+		return ds, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".mbtiles":
+		mbtiles, err := OpenMBTiles(location)
+		if err != nil {
+			return nil, err
+		}
+		return mbtiles, nil
+	case ".pmtiles":
+		pmtiles, err := OpenPMTiles(location)
+		if err != nil {
+			return nil, err
+		}
+		return pmtiles, nil
+	default:
+		return nil, fmt.Errorf("unknown tile source %s", location)
+	}
+}
@@ -0,0 +1,131 @@
+package tile
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placeholders understood by URL templates, as used by most XYZ raster servers:
+//
+//	{z}, {x}, {y}  tile zoom and position
+//	{-y}           TMS style y, counted from the bottom of the map
+//	{s}            one of the data source's subdomains
+//	{r}            "@2x" when retina tiles are requested, otherwise empty
+//
+// Any other {name} is replaced with the matching query parameter, which lets
+// API keys be placed anywhere in the URL, e.g. ".../{z}/{x}/{y}.png?key={key}".
+var templatePlaceholder = regexp.MustCompile(`\{(-?[A-Za-z0-9_]+)\}`)
+
+const (
+	DefaultTileTemplate = "{z}/{x}/{y}.png"
+	RetinaSuffix        = "@2x"
+)
+
+var ErrNoSubdomains = errors.New("tile url template uses {s} but has no subdomains")
+
+// NewTileDatasource creates a data source fetching tiles from the URL
+// template, spread over the subdomains if the template has a {s}
+func NewTileDatasource(template string, subdomains ...string) (*TileDatasource, error) {
+	ds := &TileDatasource{
+		URLTemplate: template,
+		Subdomains:  subdomains,
+		Client:      DefaultTileDatasource.Client,
+		SourceInfo:  DefaultTileDatasource.SourceInfo,
+	}
+	if err := ds.checkSubdomains(); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func (ds *TileDatasource) checkSubdomains() error {
+	if len(ds.Subdomains) == 0 && strings.Contains(ds.template(), "{s}") {
+		return fmt.Errorf("%w: %s", ErrNoSubdomains, ds.template())
+	}
+
+	return nil
+}
+
+func (ds *TileDatasource) template() string {
+	if ds.URLTemplate != "" {
+		return ds.URLTemplate
+	}
+
+	return strings.TrimSuffix(ds.BaseURL, "/") + "/" + DefaultTileTemplate
+}
+
+func (ds *TileDatasource) subdomain(x uint32, y uint32) string {
+	if len(ds.Subdomains) == 0 {
+		return ""
+	}
+
+	// Stable per tile so that caches along the way are still effective
+	return ds.Subdomains[(uint64(x)+uint64(y))%uint64(len(ds.Subdomains))]
+}
+
+// expandTemplate fills in the placeholders of the template for the tile, and
+// appends any query parameters which were not used by the template itself.
+func (ds *TileDatasource) expandTemplate(x uint32, y uint32, z uint32) (string, error) {
+	if z >= 32 || uint64(x) >= 1<<z || uint64(y) >= 1<<z {
+		return "", fmt.Errorf("%w: (%d, %d, %d) is outside the map", ErrTileNotFound, x, y, z)
+	}
+	if err := ds.checkSubdomains(); err != nil {
+		return "", err
+	}
+
+	used := map[string]bool{}
+	var missing []string
+
+	expanded := templatePlaceholder.ReplaceAllStringFunc(ds.template(), func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		switch name {
+		case "z":
+			return strconv.FormatUint(uint64(z), 10)
+		case "x":
+			return strconv.FormatUint(uint64(x), 10)
+		case "y":
+			return strconv.FormatUint(uint64(y), 10)
+		case "-y":
+			return strconv.FormatUint(uint64(1)<<z-1-uint64(y), 10)
+		case "s":
+			return ds.subdomain(x, y)
+		case "r":
+			if ds.Retina {
+				return RetinaSuffix
+			}
+			return ""
+		}
+
+		if _, ok := ds.Params[name]; !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		used[name] = true
+		return url.QueryEscape(ds.Params.Get(name))
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("tile url template has no value for %s", strings.Join(missing, ", "))
+	}
+
+	extra := url.Values{}
+	for name, values := range ds.Params {
+		if !used[name] {
+			extra[name] = values
+		}
+	}
+	if len(extra) == 0 {
+		return expanded, nil
+	}
+
+	separator := "?"
+	if strings.Contains(expanded, "?") {
+		separator = "&"
+	}
+
+	return expanded + separator + extra.Encode(), nil
+}
@@ -0,0 +1,95 @@
+package tile
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestTileDatasource_URLTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		ds       TileDatasource
+		x, y, z  uint32
+		expected string
+	}{
+		{
+			name: "base url",
+			ds:   TileDatasource{BaseURL: "http://tile.example.com/"},
+			x:    1, y: 2, z: 3,
+			expected: "http://tile.example.com/3/1/2.png",
+		},
+		{
+			name: "subdomains and retina",
+			ds: TileDatasource{
+				URLTemplate: "https://{s}.tile.example.com/{z}/{x}/{y}{r}.png",
+				Subdomains:  []string{"a", "b", "c"},
+				Retina:      true,
+			},
+			x: 4, y: 1, z: 5,
+			expected: "https://c.tile.example.com/5/4/1@2x.png",
+		},
+		{
+			name: "tms y",
+			ds: TileDatasource{
+				URLTemplate: "https://tile.example.com/{z}/{x}/{-y}{r}.jpg",
+			},
+			x: 0, y: 0, z: 2,
+			expected: "https://tile.example.com/2/0/3.jpg",
+		},
+		{
+			name: "api key placeholder and extra params",
+			ds: TileDatasource{
+				URLTemplate: "https://tile.example.com/{z}/{x}/{y}.png?key={key}",
+				Params:      url.Values{"key": {"abc 123"}, "lang": {"en"}},
+			},
+			x: 1, y: 1, z: 1,
+			expected: "https://tile.example.com/1/1/1.png?key=abc+123&lang=en",
+		},
+	}
+
+	for _, c := range cases {
+		url, err := c.ds.constructPngUrl(c.x, c.y, c.z)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if url != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, url)
+		}
+	}
+}
+
+func TestTileDatasource_URLTemplateMissingParam(t *testing.T) {
+	ds := TileDatasource{URLTemplate: "https://tile.example.com/{z}/{x}/{y}.png?key={key}"}
+	if _, err := ds.constructPngUrl(0, 0, 0); err == nil {
+		t.Errorf("expected error for missing template parameter")
+	}
+}
+
+func TestTileDatasource_URLTemplateOutsideMap(t *testing.T) {
+	ds := TileDatasource{URLTemplate: "https://tile.example.com/{z}/{x}/{-y}.png"}
+	for _, coord := range []TileCoord{{X: 0, Y: 4, Z: 2}, {X: 4, Y: 0, Z: 2}, {X: 0, Y: 0, Z: 32}} {
+		if _, err := ds.constructPngUrl(coord.X, coord.Y, coord.Z); !errors.Is(err, ErrTileNotFound) {
+			t.Errorf("%v: expected tile not found, got %v", coord, err)
+		}
+	}
+}
+
+func TestNewTileDatasource_NoSubdomains(t *testing.T) {
+	template := "https://{s}.tile.example.com/{z}/{x}/{y}.png"
+	if _, err := NewTileDatasource(template); !errors.Is(err, ErrNoSubdomains) {
+		t.Errorf("expected no subdomains error, got %v", err)
+	}
+	if source, err := OpenSource(template); !errors.Is(err, ErrNoSubdomains) || source != nil {
+		t.Errorf("expected no source, got %v: %v", source, err)
+	}
+
+	ds, err := NewTileDatasource(template, "a")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if url, _ := ds.constructPngUrl(1, 1, 1); url != "https://a.tile.example.com/1/1/1.png" {
+		t.Errorf("unexpected url %s", url)
+	}
+}
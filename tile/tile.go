@@ -3,12 +3,14 @@ package tile
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	_ "image/jpeg"
+	_ "image/png"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/paulmach/osm/osmapi"
@@ -23,11 +25,46 @@ var DefaultTileDatasource = &TileDatasource{
 	Client: &http.Client{
 		Timeout: time.Minute,
 	},
+	SourceInfo: SourceInfo{
+		MinZoom:     DefaultMinZoom,
+		MaxZoom:     DefaultMaxZoom,
+		TileSize:    DefaultTileSize,
+		Attribution: "© OpenStreetMap contributors",
+	},
 }
 
+var _ RawTileSource = (*TileDatasource)(nil)
+
 type TileDatasource struct {
-	BaseURL string
+	// BaseURL is used with the DefaultTileTemplate when URLTemplate is empty
+	BaseURL     string
+	URLTemplate string
+	Subdomains  []string
+	Retina      bool
+	Params      url.Values
 	*http.Client
+	SourceInfo
+}
+
+// IsOpenStreetMap reports whether tiles come from the OpenStreetMap tile
+// servers, whose usage policy does not allow bulk downloading
+func (ds *TileDatasource) IsOpenStreetMap() bool {
+	location := ds.URLTemplate
+	if location == "" {
+		location = ds.BaseURL
+	}
+	u, err := url.Parse(strings.Replace(location, "{s}.", "", 1))
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range []string{"openstreetmap.org", "openstreetmap.de"} {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type TileCoord struct {
@@ -37,9 +74,8 @@ type TileCoord struct {
 }
 
 type PngTile struct {
-	Tile    TileCoord
-	Image   image.Image
-	Texture *uint32
+	Tile  TileCoord
+	Image image.Image
 }
 
 var EmptyTileImage *image.RGBA
@@ -63,14 +99,15 @@ func EmptyPngTile(x, y, z uint32, width, height int) (*PngTile, error) {
 			Y: y,
 			Z: z,
 		},
-		Image:   EmptyTileImage,
-		Texture: nil,
+		Image: EmptyTileImage,
 	}, nil
 }
 
+// NewPngTile decodes a tile image, which despite the name may also be a JPEG
+// as is common for aerial imagery and offline archives.
 func NewPngTile(x uint32, y uint32, z uint32, pngBytes []byte) (*PngTile, error) {
 	pngReader := bytes.NewReader(pngBytes)
-	pngImage, err := png.Decode(pngReader)
+	pngImage, _, err := image.Decode(pngReader)
 	if err != nil {
 		return nil, err
 	}
@@ -81,17 +118,15 @@ func NewPngTile(x uint32, y uint32, z uint32, pngBytes []byte) (*PngTile, error)
 			Y: y,
 			Z: z,
 		},
-		Image:   pngImage,
-		Texture: nil,
+		Image: pngImage,
 	}, nil
 }
 
-func (ds *TileDatasource) constructPngUrl(x uint32, y uint32, z uint32) string {
-	url := fmt.Sprintf("%s/%d/%d/%d.png", ds.BaseURL, z, x, y)
-	return url
+func (ds *TileDatasource) constructPngUrl(x uint32, y uint32, z uint32) (string, error) {
+	return ds.expandTemplate(x, y, z)
 }
 
-func (ds *TileDatasource) getPngTileFromAPI(ctx context.Context, x uint32, y uint32, z uint32) (*PngTile, error) {
+func (ds *TileDatasource) getRawTileFromAPI(ctx context.Context, x uint32, y uint32, z uint32, prev *RawTile) (*RawTile, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
@@ -104,18 +139,42 @@ func (ds *TileDatasource) getPngTileFromAPI(ctx context.Context, x uint32, y uin
 		client = http.DefaultClient
 	}
 
-	url := ds.constructPngUrl(x, y, z)
+	url, err := ds.constructPngUrl(x, y, z)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	// Conditional request, so the server can tell us our copy is still good
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	raw := &RawTile{
+		Tile: TileCoord{
+			X: x,
+			Y: y,
+			Z: z,
+		},
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Expires:      expiresFromHeader(resp.Header, time.Now()),
+	}
+
 	switch resp.StatusCode {
 	case http.StatusNotFound:
 		return nil, &osmapi.NotFoundError{URL: url}
@@ -126,23 +185,58 @@ func (ds *TileDatasource) getPngTileFromAPI(ctx context.Context, x uint32, y uin
 	case http.StatusRequestURITooLong:
 		return nil, &osmapi.RequestURITooLongError{URL: url}
 
+	case http.StatusNotModified:
+		if prev == nil {
+			return nil, &osmapi.UnexpectedStatusCodeError{Code: resp.StatusCode, URL: url}
+		}
+		// Servers may omit validators on a 304, keep the ones we had
+		if raw.ETag == "" {
+			raw.ETag = prev.ETag
+		}
+		if raw.LastModified == "" {
+			raw.LastModified = prev.LastModified
+		}
+		raw.Data = prev.Data
+		raw.NotModified = true
+
+		return raw, nil
+
 	case http.StatusOK:
 		bodyBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
 		}
+		raw.Data = bodyBytes
 
-		return NewPngTile(x, y, z, bodyBytes)
+		return raw, nil
 
 	default:
-		return nil, &osmapi.UnexpectedStatusCodeError{URL: url}
+		return nil, &osmapi.UnexpectedStatusCodeError{Code: resp.StatusCode, URL: url}
+	}
+}
+
+func (ds *TileDatasource) getPngTileFromAPI(ctx context.Context, x uint32, y uint32, z uint32) (*PngTile, error) {
+	raw, err := ds.getRawTileFromAPI(ctx, x, y, z, nil)
+	if err != nil {
+		return nil, err
 	}
+
+	return NewPngTile(x, y, z, raw.Data)
+}
+
+func (ds *TileDatasource) RawTile(ctx context.Context, coord TileCoord, prev *RawTile) (*RawTile, error) {
+	return ds.getRawTileFromAPI(ctx, coord.X, coord.Y, coord.Z, prev)
+}
+
+func (ds *TileDatasource) Tile(ctx context.Context, coord TileCoord) (*PngTile, error) {
+	return ds.getPngTileFromAPI(ctx, coord.X, coord.Y, coord.Z)
 }
 
-func (ds *TileDatasource) Tile(ctx context.Context, x uint32, y uint32, z uint32) (*PngTile, error) {
-	return ds.getPngTileFromAPI(ctx, x, y, z)
+func (ds *TileDatasource) Info() SourceInfo {
+	return ds.SourceInfo.WithDefaults()
 }
 
+// Tile fetches a tile from the DefaultTileDatasource
 func Tile(ctx context.Context, x uint32, y uint32, z uint32) (*PngTile, error) {
-	return DefaultTileDatasource.Tile(ctx, x, y, z)
+	return DefaultTileDatasource.Tile(ctx, TileCoord{X: x, Y: y, Z: z})
 }
@@ -1,7 +1,12 @@
 package tile
 
 import (
+	"bytes"
 	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -25,3 +30,39 @@ func TestTile_BadRequest(t *testing.T) {
 
 	t.Logf("%v", tile)
 }
+
+func TestTileDatasource_Source(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	var source TileSource = &TileDatasource{
+		BaseURL: server.URL,
+		Client:  server.Client(),
+	}
+
+	info := source.Info()
+	if info.TileSize != DefaultTileSize || info.MaxZoom != DefaultMaxZoom {
+		t.Errorf("source info defaults not applied: %v", info)
+	}
+
+	tile, err := source.Tile(context.Background(), TileCoord{X: 1, Y: 2, Z: 3})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if requested != "/3/1/2.png" {
+		t.Errorf("unexpected tile path requested: %s", requested)
+	}
+	if tile.Tile.X != 1 || tile.Tile.Y != 2 || tile.Tile.Z != 3 {
+		t.Errorf("tile returned with incorrect coordinates")
+	}
+}
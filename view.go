@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cartog/tile"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MAX_UPLOADS_PER_FRAME and UPLOAD_FRAME_BUDGET bound how much of each
+	// frame is spent uploading tiles, so that panning stays smooth while
+	// lots of tiles are loading
+	MAX_UPLOADS_PER_FRAME = 8
+	UPLOAD_FRAME_BUDGET   = 4 * time.Millisecond
+
+	// The compass is shown in the top right corner of the view while the
+	// map is turned away from north
+	COMPASS_SIZE   = 48
+	COMPASS_MARGIN = 16
+)
+
+// CompassRect is where the compass goes in a view of the given size
+func CompassRect(viewWidth, viewHeight float32) Rect {
+	return Rect{
+		X: viewWidth - COMPASS_SIZE - COMPASS_MARGIN,
+		Y: COMPASS_MARGIN,
+		W: COMPASS_SIZE,
+		H: COMPASS_SIZE,
+	}
+}
+
+// compassImage draws a compass needle pointing up, red to the north, on a
+// round backdrop
+func compassImage(size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	center := float64(size) / 2.0
+	needleWidth := center / 3.0
+	needleLength := center * 0.8
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dX, dY := float64(x)+0.5-center, float64(y)+0.5-center
+			if math.Hypot(dX, dY) > center {
+				continue
+			}
+
+			// The needle narrows from its middle to either tip
+			c := color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+			if math.Abs(dY) < needleLength && math.Abs(dX) <= needleWidth*(1.0-math.Abs(dY)/needleLength) {
+				if dY < 0 {
+					c = color.RGBA{R: 0xd0, G: 0x20, B: 0x20, A: 0xff}
+				} else {
+					c = color.RGBA{R: 0x60, G: 0x60, B: 0x60, A: 0xff}
+				}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// MapView draws the tiles of a grid with a renderer, keeping track of where
+// in the texture atlas they have been uploaded to. Like the renderer it must
+// only be used from the thread owning the graphics context.
+type MapView struct {
+	grid     *TileGrid
+	renderer Renderer
+	atlas    *TextureAtlas
+	textures map[tile.TileCoord]AtlasSlot
+	draws    []DrawCall
+	compass  Texture
+
+	// Decoded tiles waiting to be uploaded, which may be added to from any
+	// goroutine
+	uploadsMu sync.Mutex
+	uploads   []*tile.PngTile
+}
+
+func NewMapView(grid *TileGrid, renderer Renderer) *MapView {
+	return &MapView{
+		grid:     grid,
+		renderer: renderer,
+		atlas:    NewTextureAtlas(renderer, ATLAS_SIZE, int(grid.tileWidth)),
+		textures: map[tile.TileCoord]AtlasSlot{},
+	}
+}
+
+// LoadTile uploads the tile's image and adds it to the grid
+func (v *MapView) LoadTile(pngTile *tile.PngTile) error {
+	v.deleteExpiredTextures()
+	// Replace any texture from an earlier load of the same tile
+	v.deleteTexture(pngTile.Tile)
+
+	slot, err := v.atlas.Add(pngTile.Image)
+	if err != nil {
+		return err
+	}
+	v.textures[pngTile.Tile] = slot
+	v.grid.SetTile(pngTile.Tile, *pngTile)
+
+	return nil
+}
+
+// QueueUpload converts the tile's image for uploading and queues it for the
+// next frames. Unlike the rest of the view it may be called from any
+// goroutine, and never waits on the render loop.
+func (v *MapView) QueueUpload(pngTile *tile.PngTile) error {
+	rgba, err := toRGBA(pngTile.Image)
+	if err != nil {
+		return err
+	}
+	pngTile.Image = rgba
+
+	v.uploadsMu.Lock()
+	v.uploads = append(v.uploads, pngTile)
+	v.uploadsMu.Unlock()
+
+	return nil
+}
+
+func (v *MapView) nextUpload() *tile.PngTile {
+	v.uploadsMu.Lock()
+	defer v.uploadsMu.Unlock()
+
+	if len(v.uploads) == 0 {
+		return nil
+	}
+	pngTile := v.uploads[0]
+	v.uploads[0] = nil
+	v.uploads = v.uploads[1:]
+
+	return pngTile
+}
+
+// PendingUploads is how many tiles are waiting to be uploaded
+func (v *MapView) PendingUploads() int {
+	v.uploadsMu.Lock()
+	defer v.uploadsMu.Unlock()
+
+	return len(v.uploads)
+}
+
+// UploadPending loads at most max queued tiles, stopping early once budget
+// has been spent. At least one tile is loaded if any are waiting. Tiles the
+// grid is no longer waiting for, as it moved away, are dropped.
+func (v *MapView) UploadPending(max int, budget time.Duration) int {
+	start := time.Now()
+	uploaded := 0
+	for uploaded < max {
+		pngTile := v.nextUpload()
+		if pngTile == nil {
+			break
+		}
+		if !v.grid.IsLoading(pngTile.Tile) {
+			continue
+		}
+
+		if err := v.LoadTile(pngTile); err != nil {
+			log.Printf("texture error %v: %s", pngTile.Tile, err)
+			v.grid.LoadFailed(pngTile.Tile, err)
+			continue
+		}
+		uploaded++
+
+		if time.Since(start) >= budget {
+			break
+		}
+	}
+
+	return uploaded
+}
+
+// deleteExpiredTextures frees the textures of tiles evicted from the grid
+func (v *MapView) deleteExpiredTextures() {
+	for {
+		select {
+		case expired := <-v.grid.TilesToExpire:
+			// The tile may have been loaded again since it was evicted
+			if v.grid.cache.Contains(expired.Tile) {
+				continue
+			}
+			v.deleteTexture(expired.Tile)
+		default:
+			return
+		}
+	}
+}
+
+func (v *MapView) deleteTexture(coord tile.TileCoord) {
+	slot, exists := v.textures[coord]
+	if !exists {
+		return
+	}
+
+	v.atlas.Remove(slot)
+	delete(v.textures, coord)
+}
+
+func (v *MapView) Resize(width, height uint32) {
+	v.grid.Resize(width, height)
+	v.renderer.Resize(width, height)
+}
+
+// DrawFrame draws the visible tiles which have been loaded, standing in
+// parents or children for those which have not, turned to the bearing
+func (v *MapView) DrawFrame() {
+	v.renderer.Clear()
+	v.deleteExpiredTextures()
+	v.renderer.SetRotation(v.grid.ViewRotation())
+
+	v.draws = v.draws[:0]
+	for _, patch := range v.grid.Drawable() {
+		slot, exists := v.textures[patch.Tile.Tile]
+		if !exists {
+			continue
+		}
+
+		v.draws = append(v.draws, DrawCall{
+			Texture: slot.Texture,
+			Dst:     patch.Dst,
+			Src:     slot.Src.Sub(patch.Src),
+		})
+	}
+
+	// Tiles never overlap, so they can be grouped by atlas page to keep
+	// texture switches down
+	sort.SliceStable(v.draws, func(i, j int) bool {
+		return v.draws[i].Texture < v.draws[j].Texture
+	})
+	for _, draw := range v.draws {
+		v.renderer.Draw(draw.Texture, draw.Dst, draw.Src)
+	}
+	v.drawCompass()
+	v.renderer.SetRotation(Rotation{})
+	v.renderer.Flush()
+}
+
+// drawCompass shows which way north is once the map has been turned
+func (v *MapView) drawCompass() {
+	if v.grid.Bearing() == 0 {
+		return
+	}
+	if v.compass == 0 {
+		texture, err := v.renderer.Upload(compassImage(COMPASS_SIZE))
+		if err != nil {
+			log.Printf("compass error: %s", err)
+			return
+		}
+		v.compass = texture
+	}
+
+	dst := CompassRect(v.grid.ViewSize())
+	rotation := v.grid.ViewRotation()
+	rotation.X = dst.X + dst.W/2.0
+	rotation.Y = dst.Y + dst.H/2.0
+	v.renderer.SetRotation(rotation)
+	v.renderer.Draw(v.compass, dst, FullTexture)
+}
+
+// Close frees all textures
+func (v *MapView) Close() {
+	log.Printf("freeing %d textures", len(v.textures))
+	v.deleteExpiredTextures()
+	for coord := range v.textures {
+		v.deleteTexture(coord)
+	}
+	v.atlas.Close()
+	if v.compass != 0 {
+		v.renderer.Delete(v.compass)
+		v.compass = 0
+	}
+}
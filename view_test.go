@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cartog/tile"
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+)
+
+// waitForFrame renders frames until check passes, as the main loop would
+func waitForFrame(t *testing.T, view *MapView, check func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for frame")
+		}
+		renderFrame(view)
+	}
+}
+
+// drawnTiles is what part of a texture was drawn to each area of the view in
+// the last frame
+func drawnTiles(renderer *SoftwareRenderer) map[Rect]Rect {
+	drawn := map[Rect]Rect{}
+	for _, call := range renderer.Calls {
+		drawn[call.Dst] = call.Src
+	}
+
+	return drawn
+}
+
+func TestMapView_FramePipeline(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	grid, err := NewTileGrid(source, Coord{X: 256, Y: 256, Z: 2}, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	renderer := NewSoftwareRenderer(512, 512)
+	view := NewMapView(grid, renderer)
+	handleTileLoading(view, DEFAULT_FETCH_WORKERS)
+	defer grid.Close()
+
+	// The middle four tiles of zoom 2 fill the view, with the rest of the
+	// world loaded around them
+	waitForFrame(t, view, func() bool {
+		return len(drawnTiles(renderer)) == 16
+	})
+
+	for _, c := range []struct {
+		x, y  int
+		coord tile.TileCoord
+	}{
+		{10, 10, tile.TileCoord{X: 1, Y: 1, Z: 2}},
+		{300, 10, tile.TileCoord{X: 2, Y: 1, Z: 2}},
+		{10, 300, tile.TileCoord{X: 1, Y: 2, Z: 2}},
+		{511, 511, tile.TileCoord{X: 2, Y: 2, Z: 2}},
+	} {
+		if got := renderer.Frame.RGBAAt(c.x, c.y); got != tileColor(c.coord) {
+			t.Errorf("pixel (%d, %d): expected tile %v, got %v", c.x, c.y, c.coord, got)
+		}
+	}
+
+	// Tiles follow the grid as it moves
+	grid.Move(Coord{X: 100, Y: 50})
+	view.DrawFrame()
+	if got := renderer.Frame.RGBAAt(0, 0); got != tileColor(tile.TileCoord{X: 1, Y: 1, Z: 2}) {
+		t.Errorf("unexpected pixel after move %v", got)
+	}
+	if got := renderer.Frame.RGBAAt(160, 210); got != tileColor(tile.TileCoord{X: 2, Y: 2, Z: 2}) {
+		t.Errorf("unexpected pixel after move %v", got)
+	}
+}
+
+func TestMapView_ExpiredTextures(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	grid, err := newTileGrid(source, Coord{X: 0, Y: 0, Z: 3}, 256, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	renderer := NewSoftwareRenderer(256, 256)
+	view := NewMapView(grid, renderer)
+	grid.SetCacheLimits(4, 0)
+
+	for x := uint32(0); x < 8; x++ {
+		pngTile, _ := source.Tile(context.Background(), tile.TileCoord{X: x, Y: 0, Z: 3})
+		if err := view.LoadTile(pngTile); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	view.DrawFrame()
+
+	if view.atlas.Used() != 4 || len(view.textures) != 4 {
+		t.Errorf("evicted textures not deleted, %d remain", view.atlas.Used())
+	}
+	// All tiles share one atlas texture
+	if renderer.Textures() != 1 {
+		t.Errorf("expected a single atlas texture, got %d", renderer.Textures())
+	}
+	// Only the visible tiles which were not evicted are drawn, including the
+	// last tile of the world repeated to the west
+	drawn := drawnTiles(renderer)
+	if len(drawn) != 3 ||
+		drawn[Rect{X: 0, Y: 0, W: 256, H: 256}] != view.textures[tile.TileCoord{X: 0, Y: 0, Z: 3}].Src ||
+		drawn[Rect{X: -256, Y: 0, W: 256, H: 256}] != view.textures[tile.TileCoord{X: 7, Y: 0, Z: 3}].Src {
+		t.Errorf("unexpected draw calls %v", renderer.Calls)
+	}
+
+	// Slots of evicted tiles are reused rather than growing the atlas
+	for x := uint32(0); x < 8; x++ {
+		pngTile, _ := source.Tile(context.Background(), tile.TileCoord{X: x, Y: 1, Z: 3})
+		if err := view.LoadTile(pngTile); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if view.atlas.Pages() != 1 {
+		t.Errorf("atlas grew to %d pages", view.atlas.Pages())
+	}
+
+	view.Close()
+	if renderer.Textures() != 0 {
+		t.Errorf("%d textures left after close", renderer.Textures())
+	}
+}
+
+func TestMapView_UploadPending(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	grid, err := newTileGrid(source, Coord{X: 0, Y: 0, Z: 3}, 256, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	view := NewMapView(grid, NewSoftwareRenderer(256, 256))
+
+	for x := uint32(0); x < 8; x++ {
+		coord := tile.TileCoord{X: x, Y: 0, Z: 3}
+		// Only tiles the grid is waiting for are uploaded
+		if x != 7 {
+			grid.loading.Store(coord, true)
+		}
+		pngTile, _ := source.Tile(context.Background(), coord)
+		if err := view.QueueUpload(pngTile); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	if uploaded := view.UploadPending(3, time.Second); uploaded != 3 || view.PendingUploads() != 5 {
+		t.Errorf("expected 3 uploads with 5 pending, got %d with %d pending", uploaded, view.PendingUploads())
+	}
+	// An exhausted budget still makes progress
+	if uploaded := view.UploadPending(3, 0); uploaded != 1 {
+		t.Errorf("expected 1 upload without any budget, got %d", uploaded)
+	}
+	if uploaded := view.UploadPending(MAX_UPLOADS_PER_FRAME, time.Second); uploaded != 3 || view.PendingUploads() != 0 {
+		t.Errorf("expected the rest of the grid's tiles to upload, got %d", uploaded)
+	}
+	if len(view.textures) != 7 || grid.IsLoading(tile.TileCoord{X: 0, Y: 0, Z: 3}) {
+		t.Errorf("unexpected tiles loaded %d", len(view.textures))
+	}
+}
+
+// failingRenderer cannot create textures
+type failingRenderer struct {
+	*SoftwareRenderer
+}
+
+func (r failingRenderer) Upload(img image.Image) (Texture, error) {
+	return 0, errors.New("out of memory")
+}
+
+func (r failingRenderer) NewTexture(width, height int) (Texture, error) {
+	return 0, errors.New("out of memory")
+}
+
+func TestMapView_UploadFailed(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	grid, err := newTileGrid(source, Coord{X: 0, Y: 0, Z: 3}, 256, 256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	view := NewMapView(grid, failingRenderer{NewSoftwareRenderer(256, 256)})
+
+	coord := tile.TileCoord{X: 0, Y: 0, Z: 3}
+	grid.loading.Store(coord, true)
+	pngTile, _ := source.Tile(context.Background(), coord)
+	if err := view.QueueUpload(pngTile); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// The tile is no longer waited for, and is fetched again later
+	if uploaded := view.UploadPending(MAX_UPLOADS_PER_FRAME, time.Second); uploaded != 0 {
+		t.Errorf("expected nothing uploaded, got %d", uploaded)
+	}
+	if grid.IsLoading(coord) || grid.canFetch(coord, time.Now()) || !grid.canFetch(coord, time.Now().Add(FETCH_RETRY_DELAY)) {
+		t.Errorf("tile %v which failed to upload still loading", coord)
+	}
+}
+
+func TestMapView_Bearing(t *testing.T) {
+	source := &colorSource{*newTestSource()}
+	grid, err := newTileGrid(source, Coord{X: 256, Y: 256, Z: 2}, 512, 512)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	renderer := NewSoftwareRenderer(512, 512)
+	view := NewMapView(grid, renderer)
+	for x := uint32(0); x < 4; x++ {
+		for y := uint32(0); y < 4; y++ {
+			pngTile, _ := source.Tile(context.Background(), tile.TileCoord{X: x, Y: y, Z: 2})
+			if err := view.LoadTile(pngTile); err != nil {
+				t.Fatalf("%s", err)
+			}
+		}
+	}
+
+	// Facing east, the tiles to the east of the middle are at the top
+	grid.SetBearing(90)
+	view.DrawFrame()
+	if got := renderer.Frame.RGBAAt(10, 10); got != tileColor(tile.TileCoord{X: 2, Y: 1, Z: 2}) {
+		t.Errorf("unexpected pixel in the top left %v", got)
+	}
+	if got := renderer.Frame.RGBAAt(10, 500); got != tileColor(tile.TileCoord{X: 1, Y: 1, Z: 2}) {
+		t.Errorf("unexpected pixel in the bottom left %v", got)
+	}
+
+	// The compass needle points to the north, to the left
+	compass := CompassRect(512, 512)
+	north := renderer.Frame.RGBAAt(int(compass.X+compass.W/2.0-12), int(compass.Y+compass.H/2.0))
+	south := renderer.Frame.RGBAAt(int(compass.X+compass.W/2.0+12), int(compass.Y+compass.H/2.0))
+	if north.R <= north.G || south.R != south.G {
+		t.Errorf("compass not pointing north, %v to the left and %v to the right", north, south)
+	}
+
+	// Tapping it turns back to north, hiding it
+	applyMovement(grid, Movement{Tap: true, Anchored: true, AnchorX: compass.X + 1, AnchorY: compass.Y + 1})
+	view.DrawFrame()
+	if grid.Bearing() != 0 {
+		t.Errorf("expected to face north, got bearing %f", grid.Bearing())
+	}
+	for _, call := range renderer.Calls {
+		if call.Texture == view.compass || call.Rotation != (Rotation{X: 256, Y: 256}) {
+			t.Errorf("unexpected draw call %v", call)
+		}
+	}
+
+	view.Close()
+	if renderer.Textures() != 0 {
+		t.Errorf("%d textures left after close", renderer.Textures())
+	}
+}
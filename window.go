@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/go-gl/glfw/v3.3/glfw"
 )
 
@@ -19,20 +21,52 @@ func getInitialResolution() (int, int) {
 	return mode.Width, mode.Height
 }
 
-func NewWindow(title string) (*WindowState, error) {
+// NewWindow opens a window of the given size, where zero means the size of
+// the screen. Fullscreen windows always take the size of the screen. The
+// window has the context the renderer needs, OpenGL ES 2.0 for
+// RENDERER_GLES2, an OpenGL 3.3 core profile for RENDERER_GL33, otherwise
+// OpenGL 2.1. Keys do what the keymap binds them to.
+func NewWindow(title string, width, height uint32, fullscreen bool, renderer string, keymap *Keymap) (*WindowState, error) {
+	glfw.DefaultWindowHints()
 	glfw.WindowHint(glfw.Resizable, glfw.True)
-	glfw.WindowHint(glfw.ContextVersionMajor, 2)
-	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	switch renderer {
+	case RENDERER_GLES2:
+		glfw.WindowHint(glfw.ClientAPI, glfw.OpenGLESAPI)
+		glfw.WindowHint(glfw.ContextVersionMajor, 2)
+		glfw.WindowHint(glfw.ContextVersionMinor, 0)
+	case RENDERER_GL33:
+		glfw.WindowHint(glfw.ClientAPI, glfw.OpenGLAPI)
+		glfw.WindowHint(glfw.ContextVersionMajor, 3)
+		glfw.WindowHint(glfw.ContextVersionMinor, 3)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+		// macOS only gives core profiles to forward compatible contexts
+		glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	default:
+		glfw.WindowHint(glfw.ClientAPI, glfw.OpenGLAPI)
+		glfw.WindowHint(glfw.ContextVersionMajor, 2)
+		glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	}
 
 	screenW, screenH := getInitialResolution()
+	var monitor *glfw.Monitor
+	if fullscreen {
+		monitor = glfw.GetPrimaryMonitor()
+	} else {
+		if width > 0 {
+			screenW = int(width)
+		}
+		if height > 0 {
+			screenH = int(height)
+		}
+	}
 
-	window, err := glfw.CreateWindow(screenW, screenH, title, nil, nil)
+	window, err := glfw.CreateWindow(screenW, screenH, title, monitor, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	window.MakeContextCurrent()
 
-	inputState, err := NewInputState(window)
+	inputState, err := NewInputState(window, keymap)
 	if err != nil {
 		panic(err)
 	}
@@ -53,8 +87,22 @@ func (state *WindowState) Close() {
 	state.input.Close()
 }
 
-func (state *WindowState) GetMovementDelta() chan Coord {
-	return state.input.MoveDelta
+// Destroy closes the window itself, for when it turns out to be unusable
+func (state *WindowState) Destroy() {
+	state.Close()
+	state.Window.Destroy()
+}
+
+// Update recognises input which happens over time rather than on an event,
+// such as long presses
+func (state *WindowState) Update(now time.Time) {
+	state.input.Update(now)
+}
+
+// TakeMovements hands over the movements the input has made since they were
+// last taken
+func (state *WindowState) TakeMovements() []Movement {
+	return state.input.TakeMovements()
 }
 
 func (state *WindowState) SetResizeCallback(handler func(width, height uint32)) {
@@ -66,6 +114,6 @@ func (state *WindowState) windowResizeCallback(_ *glfw.Window, width, height int
 	state.Height = uint32(height)
 
 	if state.resizeCallback != nil {
-		go state.resizeCallback(state.Width, state.Height)
+		state.resizeCallback(state.Width, state.Height)
 	}
 }